@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var claimsCMD = &cobra.Command{
+	Use:   "claims",
+	Short: "管理拆分后的权利要求",
+}
+
+var claimsBackfillCMD = &cobra.Command{
+	Use:   "backfill",
+	Short: "从已保存的主权项拆分权利要求，可重复执行",
+	Run: func(cmd *cobra.Command, args []string) {
+		// 确保所有表都已经存在
+		spider.NewMysqlTaskHandler()
+		total, err := spider.BackfillClaims(claimsBatch)
+		if err != nil {
+			logrus.Fatalf("已处理 %d 个专利，之后失败: %v", total, err)
+		}
+		logrus.Infof("已处理 %d 个专利", total)
+	},
+}
+
+var claimsShowCMD = &cobra.Command{
+	Use:   "show <公开号>",
+	Short: "显示专利的各项权利要求及引用关系",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		publicationNo, err := spider.ResolvePublicationNo(args[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		claims, err := spider.FindClaims(publicationNo)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		for _, claim := range claims {
+			kind := "独立"
+			if !claim.Independent {
+				kind = "引用 " + claim.DependsOn
+			}
+			fmt.Printf("%d. [%s] %s\n", claim.Number, kind, claim.Text)
+		}
+	},
+}
+
+var claimsBatch int
+
+func init() {
+	claimsBackfillCMD.Flags().IntVarP(&claimsBatch, "batch", "b", 500, "每批处理的专利数")
+	claimsCMD.AddCommand(claimsBackfillCMD)
+	claimsCMD.AddCommand(claimsShowCMD)
+}
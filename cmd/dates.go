@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var datesCMD = &cobra.Command{
+	Use:   "dates",
+	Short: "管理专利的日期",
+}
+
+var datesBackfillCMD = &cobra.Command{
+	Use:   "backfill",
+	Short: "为已保存的专利解析申请日、公开公告日与授权公告日，并修正年份，可重复执行",
+	Run: func(cmd *cobra.Command, args []string) {
+		// 确保日期列已经存在
+		spider.NewMysqlTaskHandler()
+		total, err := spider.BackfillDates(datesBatch)
+		if err != nil {
+			logrus.Fatalf("已处理 %d 个专利，之后失败: %v", total, err)
+		}
+		logrus.Infof("已处理 %d 个专利", total)
+	},
+}
+
+var datesBatch int
+
+func init() {
+	datesBackfillCMD.Flags().IntVarP(&datesBatch, "batch", "b", 500, "每批处理的专利数")
+	datesCMD.AddCommand(datesBackfillCMD)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var driftCMD = &cobra.Command{
+	Use:   "drift",
+	Short: "管理解析异常检测的填充率基线",
+}
+
+var driftBaselineCMD = &cobra.Command{
+	Use:   "baseline",
+	Short: "用已保存的专利生成字段填充率基线，覆盖之前的基线",
+	Long:  `用已保存的专利生成字段填充率基线，覆盖之前的基线。应在确认解析正常后执行，可用 --parser-version 只统计某个版本的规则解析的专利`,
+	Run: func(cmd *cobra.Command, args []string) {
+		spider.NewMysqlTaskHandler()
+		baseline, err := spider.ComputeDriftBaseline(driftParserVersion, driftBaselineLimit)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if len(baseline) == 0 {
+			logrus.Fatal("没有符合条件的专利，基线没有更新")
+		}
+		for dbCode, rates := range baseline {
+			fields := make([]string, 0, len(rates))
+			for field := range rates {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				fmt.Printf("%s\t%s\t%.0f%%\n", dbCode, field, rates[field]*100)
+			}
+		}
+		if err := spider.SaveDriftBaseline(driftBaselineFile, baseline); err != nil {
+			logrus.Fatalf("保存填充率基线失败: %v", err)
+		}
+		logrus.Infof("已保存填充率基线: %s", driftBaselineFile)
+	},
+}
+
+var (
+	driftParserVersion string
+	driftBaselineLimit int
+	driftBaselineFile  string
+)
+
+func init() {
+	driftBaselineCMD.Flags().StringVarP(&driftParserVersion, "parser-version", "", "", "只统计该版本的规则解析的专利，为空时不限版本")
+	driftBaselineCMD.Flags().IntVarP(&driftBaselineLimit, "limit", "", 1000, "每个数据库统计最近保存的多少个专利")
+	driftBaselineCMD.Flags().StringVarP(&driftBaselineFile, "file", "", spider.DefaultDriftConfig().BaselineFile, "基线保存的文件")
+	driftCMD.AddCommand(driftBaselineCMD)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var entitiesCMD = &cobra.Command{
+	Use:   "entities",
+	Short: "管理发明人、申请人、代理人与代理机构",
+}
+
+var entitiesBackfillCMD = &cobra.Command{
+	Use:   "backfill",
+	Short: "为已保存的专利拆分发明人、申请人、代理人与代理机构，可重复执行",
+	Run: func(cmd *cobra.Command, args []string) {
+		// 确保所有表都已经存在
+		spider.NewMysqlTaskHandler()
+		total, err := spider.BackfillEntities(entitiesBatch)
+		if err != nil {
+			logrus.Fatalf("已处理 %d 个专利，之后失败: %v", total, err)
+		}
+		logrus.Infof("已处理 %d 个专利", total)
+	},
+}
+
+var entitiesPatentsCMD = &cobra.Command{
+	Use:   "patents <名称>",
+	Short: "查询某个发明人、申请人等的所有专利",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		spider.NewMysqlTaskHandler()
+		patents, err := spider.FindPatentsByEntity(args[0], entitiesRole)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		for _, patent := range patents {
+			fmt.Printf("%s\t%s\t%s\n", patent.PublicationNo, patent.PublicationDate, patent.Title)
+		}
+		fmt.Printf("共 %d 个专利\n", len(patents))
+	},
+}
+
+var (
+	entitiesBatch int
+	entitiesRole  string
+)
+
+func init() {
+	entitiesBackfillCMD.Flags().IntVarP(&entitiesBatch, "batch", "b", 500, "每批处理的专利数")
+	entitiesPatentsCMD.Flags().StringVarP(&entitiesRole, "role", "r", "", "只查询该角色：inventor、applicant、agent、agency，为空时不限")
+	entitiesCMD.AddCommand(entitiesBackfillCMD)
+	entitiesCMD.AddCommand(entitiesPatentsCMD)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var fullTextCMD = &cobra.Command{
+	Use:   "fulltext",
+	Short: "管理专利全文",
+}
+
+var fullTextBackfillCMD = &cobra.Command{
+	Use:   "backfill",
+	Short: "下载有下载链接但还没有下载的全文，如爬取时队列已满或退出时没有下载完的全文，可重复执行",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := spider.ShutdownContext()
+		defer stop()
+		th := spider.NewMysqlTaskHandler()
+		s := spider.NewSpider(th, 1, 1, 1, time.Second, time.Second*2, time.Minute, spider.DefaultDrainTimeout, fullTextProxy)
+		total, err := s.BackfillFullText(ctx, fullTextBackfillInterval, fullTextBatch)
+		if err != nil {
+			logrus.Fatalf("已下载 %d 个全文，之后失败: %v", total, err)
+		}
+		logrus.Infof("已下载 %d 个全文", total)
+	},
+}
+
+var (
+	fullTextBatch            int
+	fullTextBackfillInterval time.Duration
+	fullTextProxy            string
+)
+
+func init() {
+	fullTextBackfillCMD.Flags().IntVarP(&fullTextBatch, "batch", "b", 100, "每批查询的专利数")
+	fullTextBackfillCMD.Flags().DurationVarP(&fullTextBackfillInterval, "interval", "", spider.DefaultFullTextInterval, "两次全文下载之间的最小间隔")
+	fullTextBackfillCMD.Flags().StringVarP(&fullTextProxy, "proxy", "P", "", "隧道代理地址，格式为 http://ip:port:username@password")
+	fullTextCMD.AddCommand(fullTextBackfillCMD)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var historyCMD = &cobra.Command{
+	Use:   "history <公开号>",
+	Short: "显示专利在重新爬取时各列的变化",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		publicationNo, err := spider.ResolvePublicationNo(args[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		changes, err := spider.FindPatentChanges(publicationNo)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if len(changes) == 0 {
+			fmt.Println("没有变化记录")
+			return
+		}
+		for _, change := range changes {
+			fmt.Printf("%s 任务 %d 解析器 %s %s: %q -> %q\n", change.CrawledAt.Format("2006-01-02 15:04:05"),
+				change.TaskID, change.ParserVersion, change.Field, change.OldValue, change.NewValue)
+		}
+	},
+}
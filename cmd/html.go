@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var htmlCMD = &cobra.Command{
+	Use:   "html",
+	Short: "管理保存的详情页 html",
+}
+
+var htmlShowCMD = &cobra.Command{
+	Use:   "show <公开号>",
+	Short: "输出保存的详情页 html，公开号可以是任务中跳转前的公开号",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openHtmlStore(htmlStore)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer store.Close()
+		body, err := spider.LoadHtml(store, args[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		_, _ = os.Stdout.Write(body)
+	},
+}
+
+var htmlImportCMD = &cobra.Command{
+	Use:   "import",
+	Short: "把 data/html 中每个页面一个文件的 html 导入归档",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := spider.OpenArchiveHtmlStore(spider.HtmlArchiveDir, htmlShardSize<<20)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		total, err := spider.ImportHtmlDir(spider.HtmlDir, store)
+		if closeErr := store.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			logrus.Fatalf("已导入 %d 个页面，之后失败: %v", total, err)
+		}
+		logrus.Infof("已导入 %d 个页面", total)
+	},
+}
+
+// openHtmlStore 按保存方式打开 data 目录中的 html
+func openHtmlStore(kind string) (spider.HtmlStore, error) {
+	dir := spider.WARCDir
+	switch kind {
+	case spider.HtmlStoreArchive:
+		dir = spider.HtmlArchiveDir
+	case spider.HtmlStoreDir:
+		dir = spider.HtmlDir
+	}
+	return spider.NewHtmlStore(kind, dir, htmlShardSize<<20)
+}
+
+func init() {
+	htmlCMD.PersistentFlags().StringVarP(&htmlStore, "store", "", spider.HtmlStoreWARC, "页面的保存方式：warc、archive 或 dir")
+	htmlImportCMD.Flags().Int64VarP(&htmlShardSize, "shard-size", "", 0, "归档每个分片的大小上限，单位 MB，0 表示默认的 256 MB")
+	htmlCMD.AddCommand(htmlShowCMD)
+	htmlCMD.AddCommand(htmlImportCMD)
+}
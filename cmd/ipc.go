@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/db"
+	"spider/internal/pkg/spider"
+)
+
+var ipcCMD = &cobra.Command{
+	Use:   "ipc",
+	Short: "管理 IPC 分类号",
+}
+
+var ipcBackfillCMD = &cobra.Command{
+	Use:   "backfill",
+	Short: "为已保存的专利拆分 IPC 分类号，可重复执行",
+	Run: func(cmd *cobra.Command, args []string) {
+		// 确保所有表都已经存在，并写入 IPC 层级
+		spider.NewMysqlTaskHandler()
+		total, err := spider.BackfillIPCs(ipcBatch)
+		if err != nil {
+			logrus.Fatalf("已处理 %d 个专利，之后失败: %v", total, err)
+		}
+		logrus.Infof("已处理 %d 个专利", total)
+	},
+}
+
+var ipcShowCMD = &cobra.Command{
+	Use:   "show <分类号>",
+	Short: "显示分类号从部到小组的各级代码与名称",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		nodes, err := spider.IPCHierarchy(args[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		// 小类及以下的名称没有打包，从导入的分类表中查找
+		spider.NewMysqlTaskHandler()
+		if err := spider.FillIPCTitles(nodes); err != nil {
+			logrus.Fatal(err)
+		}
+		for _, node := range nodes {
+			fmt.Printf("%-10s %-12s %s\n", node.Level, node.Code, node.Title)
+		}
+	},
+}
+
+var ipcImportCMD = &cobra.Command{
+	Use:   "import <分类表文件>",
+	Short: "从“代码<TAB>名称”格式的分类表导入小类、大组等的名称，可重复执行",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		spider.NewMysqlTaskHandler()
+		f, err := os.Open(args[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer f.Close()
+		total, err := spider.ImportIPCNodes(db.GetDB(), f)
+		if err != nil {
+			logrus.Fatalf("导入分类表失败: %v", err)
+		}
+		logrus.Infof("已导入 %d 个节点", total)
+	},
+}
+
+var ipcBatch int
+
+func init() {
+	ipcBackfillCMD.Flags().IntVarP(&ipcBatch, "batch", "b", 500, "每批处理的专利数")
+	ipcCMD.AddCommand(ipcBackfillCMD)
+	ipcCMD.AddCommand(ipcShowCMD)
+	ipcCMD.AddCommand(ipcImportCMD)
+}
@@ -30,6 +30,19 @@ func init() {
 	rootCMD.PersistentFlags().BoolVarP(&isDebug, "debug", "", false, "debug level log")
 	rootCMD.PersistentFlags().BoolVarP(&db.TestEnvEnabled, "test", "t", false, "开启测试环境")
 	rootCMD.AddCommand(runCMD)
+	rootCMD.AddCommand(rulesCMD)
+	rootCMD.AddCommand(statusCMD)
+	rootCMD.AddCommand(entitiesCMD)
+	rootCMD.AddCommand(datesCMD)
+	rootCMD.AddCommand(ipcCMD)
+	rootCMD.AddCommand(regionsCMD)
+	rootCMD.AddCommand(textCMD)
+	rootCMD.AddCommand(numbersCMD)
+	rootCMD.AddCommand(claimsCMD)
+	rootCMD.AddCommand(historyCMD)
+	rootCMD.AddCommand(htmlCMD)
+	rootCMD.AddCommand(fullTextCMD)
+	rootCMD.AddCommand(driftCMD)
 }
 
 func initConfig() {
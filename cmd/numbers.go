@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var numbersCMD = &cobra.Command{
+	Use:   "numbers",
+	Short: "管理公开号与申请号",
+}
+
+var numbersBackfillCMD = &cobra.Command{
+	Use:   "backfill",
+	Short: "为已保存的专利拆分公开号并规范化申请号，可重复执行",
+	Run: func(cmd *cobra.Command, args []string) {
+		spider.NewMysqlTaskHandler()
+		total, err := spider.BackfillNumbers(numbersBatch)
+		if err != nil {
+			logrus.Fatalf("已处理 %d 个专利，之后失败: %v", total, err)
+		}
+		logrus.Infof("已处理 %d 个专利", total)
+	},
+}
+
+var numbersStagesCMD = &cobra.Command{
+	Use:   "stages <申请号>",
+	Short: "查询同一申请的所有文献，如申请公布与授权公告",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		spider.NewMysqlTaskHandler()
+		patents, err := spider.FindApplicationStages(args[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		for _, patent := range patents {
+			fmt.Printf("%s\t%s\t%s\n", patent.PublicationNo, patent.KindCategory, patent.Title)
+		}
+		fmt.Printf("共 %d 个文献\n", len(patents))
+	},
+}
+
+var numbersBatch int
+
+func init() {
+	numbersBackfillCMD.Flags().IntVarP(&numbersBatch, "batch", "b", 500, "每批处理的专利数")
+	numbersCMD.AddCommand(numbersBackfillCMD)
+	numbersCMD.AddCommand(numbersStagesCMD)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var regionsCMD = &cobra.Command{
+	Use:   "regions",
+	Short: "管理申请人所在的省、市、区县",
+}
+
+var regionsBackfillCMD = &cobra.Command{
+	Use:   "backfill",
+	Short: "为已保存的专利解析国省代码与地址，可重复执行",
+	Run: func(cmd *cobra.Command, args []string) {
+		// 确保地区列已经存在
+		spider.NewMysqlTaskHandler()
+		total, err := spider.BackfillRegions(regionsBatch)
+		if err != nil {
+			logrus.Fatalf("已处理 %d 个专利，之后失败: %v", total, err)
+		}
+		logrus.Infof("已处理 %d 个专利", total)
+	},
+}
+
+var regionsBatch int
+
+func init() {
+	regionsBackfillCMD.Flags().IntVarP(&regionsBatch, "batch", "b", 500, "每批处理的专利数")
+	regionsCMD.AddCommand(regionsBackfillCMD)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var rulesCMD = &cobra.Command{
+	Use:   "rules",
+	Short: "管理字段映射规则",
+	Long:  `管理字段映射规则。知网改了页面中的标签时，修改规则后推送到数据库，所有正在运行的爬虫都会自动切换，不需要重新分发程序`,
+}
+
+var rulesDumpCMD = &cobra.Command{
+	Use:   "dump",
+	Short: "输出内置的字段映射规则，可作为修改的起点",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println(string(spider.DefaultCnkiRules()))
+	},
+}
+
+var rulesCheckCMD = &cobra.Command{
+	Use:   "check <规则文件>",
+	Short: "校验字段映射规则文件",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rs, err := spider.LoadRuleSetFile(args[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("规则合法，版本: %s，共 %d 条", rs.Version, len(rs.Fields))
+	},
+}
+
+var rulesPushCMD = &cobra.Command{
+	Use:   "push <规则文件>",
+	Short: "校验字段映射规则文件并推送到数据库",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		content, err := os.ReadFile(args[0])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		// 确保规则表已经存在
+		spider.NewMysqlTaskHandler()
+		rs, err := spider.PushRuleSet(spider.SourceCnki, content)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("规则已推送，版本: %s", rs.Version)
+	},
+}
+
+func init() {
+	rulesCMD.AddCommand(rulesDumpCMD)
+	rulesCMD.AddCommand(rulesCheckCMD)
+	rulesCMD.AddCommand(rulesPushCMD)
+}
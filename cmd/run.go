@@ -1,6 +1,8 @@
 package main
 
 import (
+	_ "expvar"
+	"net/http"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -17,9 +19,74 @@ var runCMD = &cobra.Command{
 }
 
 func runCMDFunc(cmd *cobra.Command, args []string) {
-	s := spider.NewSpider(spider.NewMysqlTaskHandler(), concurrency, taskBatch, taskPoolCap, minSleepTime, maxSleepTime, waitForTaskSleepTime, proxy)
+	ctx, stop := spider.ShutdownContext()
+	defer stop()
+	th := spider.NewMysqlTaskHandler()
+	th.SetUpsert(upsert)
+	s := spider.NewSpider(th, concurrency, taskBatch, taskPoolCap, minSleepTime, maxSleepTime, waitForTaskSleepTime, shutdownTimeout, proxy)
+	store, err := openHtmlStore(htmlStore)
+	if err != nil {
+		logrus.Fatalf("打开 html 归档失败: %v", err)
+	}
+	s.SetHtmlStore(store)
+	if recordDir != "" {
+		logrus.Infof("录制模式已开启，请求与响应将保存到 %s", recordDir)
+		s.SetTransport(spider.NewRecordTransport(s.Transport(), recordDir))
+	}
+	s.SetDiscoverTasks(discoverTasks)
+	policy, err := spider.ParseValidationPolicy(validationPolicy)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	validator, err := spider.NewValidator(validationDisabled...)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	s.SetValidation(validator, policy)
+	if drift {
+		cfg := spider.DefaultDriftConfig()
+		cfg.Window, cfg.Drop, cfg.Pause, cfg.Webhook = driftWindow, driftDrop, driftPause, driftWebhook
+		s.SetDrift(cfg)
+	}
+	if metricsAddr != "" {
+		// expvar 注册了 /debug/vars，填充率等指标都在其中
+		go func() {
+			logrus.Infof("指标地址: http://%s/debug/vars", metricsAddr)
+			if err := http.ListenAndServe(metricsAddr, nil); err != nil {
+				logrus.Errorf("指标服务退出: %v", err)
+			}
+		}()
+	}
+	if fullText {
+		s.SetFullText(fullTextInterval)
+	}
+	if bulkSize > 0 {
+		s.SetBulkWrite(bulkSize, bulkInterval, bulkBuffer)
+	}
+
+	// 字段映射规则：指定的文件优先，其次是数据库中推送的规则，最后是内置规则
+	cnki := spider.NewCnkiSource()
+	cnki.SetDriftMonitor(s.Drift())
+	rendering, err := spider.ParseTextRendering(textRendering)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	cnki.SetTextRendering(rendering)
+	if rulesFile != "" {
+		rs, err := spider.LoadRuleSetFile(rulesFile)
+		if err != nil {
+			logrus.Fatalf("读取字段映射规则失败: %v", err)
+		}
+		cnki.SetRuleSet(rs)
+	} else if rulesRefreshInterval > 0 {
+		// 收到退出信号后不再检查，退出等待期间不再查询数据库
+		cnki.WatchCentralRules(ctx, rulesRefreshInterval)
+	}
+	logrus.Infof("字段映射规则版本: %s", cnki.RuleSet().Version)
+	s.RegisterSource(cnki)
+
 	logrus.Info("程序已启动")
-	s.GoRun()
+	s.GoRunContext(ctx, stop)
 
 }
 
@@ -27,11 +94,39 @@ var (
 	minSleepTime         time.Duration
 	maxSleepTime         time.Duration
 	waitForTaskSleepTime time.Duration
+	shutdownTimeout      time.Duration
 	concurrency          int
 	taskBatch            int
 	taskPoolCap          int
 
-	proxy string
+	proxy     string
+	recordDir string
+
+	htmlStore     string
+	htmlShardSize int64
+
+	discoverTasks    bool
+	fullText         bool
+	fullTextInterval time.Duration
+
+	bulkSize     int
+	bulkInterval time.Duration
+	bulkBuffer   int
+	upsert       bool
+
+	rulesFile            string
+	rulesRefreshInterval time.Duration
+	textRendering        string
+
+	validationPolicy   string
+	validationDisabled []string
+
+	drift        bool
+	driftWindow  int
+	driftDrop    float64
+	driftPause   time.Duration
+	driftWebhook string
+	metricsAddr  string
 )
 
 func init() {
@@ -41,5 +136,27 @@ func init() {
 	runCMD.Flags().DurationVarP(&minSleepTime, "min", "m", time.Second, "两次请求最小间隔时间，下限0.5s")
 	runCMD.Flags().DurationVarP(&maxSleepTime, "max", "M", time.Second*2, "两次请求最大间隔时间，上限10s，")
 	runCMD.Flags().DurationVarP(&waitForTaskSleepTime, "wait", "w", time.Minute*5, "没有任务时，多久再获取一次任务，范围 1min~1h")
+	runCMD.Flags().DurationVarP(&shutdownTimeout, "shutdown-timeout", "", spider.DefaultDrainTimeout, "退出时等待进行中的任务与待保存数据的最长时间")
 	runCMD.Flags().StringVarP(&proxy, "proxy", "P", "", "隧道代理地址，格式为 http://ip:port:username@password")
+	runCMD.Flags().BoolVarP(&discoverTasks, "discover", "", false, "把引证文献与被引文献中的专利加入任务库")
+	runCMD.Flags().BoolVarP(&fullText, "fulltext", "", false, "下载专利全文（PDF 或 CAJ），保存到 data/fulltext 中")
+	runCMD.Flags().DurationVarP(&fullTextInterval, "fulltext-interval", "", spider.DefaultFullTextInterval, "两次全文下载之间的最小间隔")
+	runCMD.Flags().IntVarP(&bulkSize, "bulk-size", "", 0, "批量写入，每批保存的专利数，0 表示每个专利单独保存")
+	runCMD.Flags().DurationVarP(&bulkInterval, "bulk-interval", "", spider.DefaultBulkInterval, "批量写入时，不足一批的专利最多等待多久保存")
+	runCMD.Flags().IntVarP(&bulkBuffer, "bulk-buffer", "", spider.DefaultBulkBuffer, "批量写入的缓冲区容量，满了之后爬取会暂停")
+	runCMD.Flags().BoolVarP(&upsert, "upsert", "", false, "专利已经存在时用本次爬取的结果更新变化的列，并在 patent_changes 中记录变化")
+	runCMD.Flags().StringVarP(&rulesFile, "rules", "", "", "字段映射规则文件，指定后不再使用数据库中推送的规则")
+	runCMD.Flags().DurationVarP(&rulesRefreshInterval, "rules-refresh", "", time.Minute*10, "多久检查一次数据库中推送的字段映射规则，0 表示不检查")
+	runCMD.Flags().StringVarP(&textRendering, "text-render", "", string(spider.RenderPlain), "摘要与主权项的渲染方式：plain 纯文本，markup 保留 <sub>、<sup>、<i> 标记")
+	runCMD.Flags().StringVarP(&validationPolicy, "validation", "", string(spider.PolicyReject), "校验不通过时的处理方式：reject 不保存并稍后重试，quarantine 保存到隔离表，accept 照常保存")
+	runCMD.Flags().StringSliceVarP(&validationDisabled, "validation-disable", "", nil, "不执行的校验规则，如 applicant_address,application_no_format")
+	runCMD.Flags().BoolVarP(&drift, "drift", "", true, "统计字段填充率，大幅低于基线时告警并暂停爬取，基线保存在 data/drift_baseline.json")
+	runCMD.Flags().IntVarP(&driftWindow, "drift-window", "", 200, "统计填充率的滚动窗口大小，即最近多少个页面")
+	runCMD.Flags().Float64VarP(&driftDrop, "drift-drop", "", 0.5, "填充率相对基线下降超过该比例时告警")
+	runCMD.Flags().DurationVarP(&driftPause, "drift-pause", "", time.Minute*30, "告警后暂停爬取的时间，0 表示只告警不暂停")
+	runCMD.Flags().StringVarP(&driftWebhook, "drift-webhook", "", "", "告警时以 JSON POST 到该地址")
+	runCMD.Flags().StringVarP(&metricsAddr, "metrics", "", "", "指标服务的监听地址，如 127.0.0.1:9090，为空表示不开启")
+	runCMD.Flags().StringVarP(&htmlStore, "html-store", "", spider.HtmlStoreWARC, "页面的保存方式：warc 所有请求与响应以 WARC 格式保存到 data/warc，archive 压缩后写入 data/html_archive 中的分片，dir 每个页面一个文件保存到 data/html")
+	runCMD.Flags().Int64VarP(&htmlShardSize, "html-shard-size", "", 0, "WARC 文件或归档分片的大小上限，单位 MB，0 表示默认值（WARC 1024 MB，归档 256 MB）")
+	runCMD.Flags().StringVarP(&recordDir, "record", "", "", "录制模式，把所有请求与响应保存到该目录，用作回放测试的数据")
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var statusCMD = &cobra.Command{
+	Use:   "status",
+	Short: "查看所有爬虫汇总后的运行状态",
+	Run:   statusCMDFunc,
+}
+
+var topLabels int
+
+func statusCMDFunc(cmd *cobra.Command, args []string) {
+	// 确保所有表都已经存在
+	spider.NewMysqlTaskHandler()
+	status, err := spider.GetStatus(topLabels)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("任务总数：%d\n已完成：%d\n未完成：%d\n已保存专利：%d\n不同的申请：%d\n",
+		status.TaskTotal, status.TaskFinished, status.TaskTotal-status.TaskFinished, status.PatentTotal, status.InventionTotal)
+	if len(status.UnknownLabels) == 0 {
+		fmt.Println("没有发现字段映射规则中没有的标签")
+		return
+	}
+	fmt.Println("字段映射规则中没有的标签：")
+	for _, label := range status.UnknownLabels {
+		fmt.Printf("  %s 出现 %d 次，最近一次：%s %s，值：%s\n", label.Label, label.Occurrences,
+			label.LastSeenAt.Format("2006-01-02 15:04:05"), label.ExamplePatent, label.ExampleValue)
+	}
+}
+
+func init() {
+	statusCMD.Flags().IntVarP(&topLabels, "top", "n", 20, "最多显示多少个未识别的标签")
+}
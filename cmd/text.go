@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"spider/internal/pkg/spider"
+)
+
+var textCMD = &cobra.Command{
+	Use:   "text",
+	Short: "管理专利文本的规范化",
+}
+
+var textBackfillCMD = &cobra.Command{
+	Use:   "backfill",
+	Short: "规范化已保存专利的文本，并尽量恢复英文单词之间的空格，可重复执行",
+	Run: func(cmd *cobra.Command, args []string) {
+		spider.NewMysqlTaskHandler()
+		total, err := spider.BackfillText(textBatch)
+		if err != nil {
+			logrus.Fatalf("已处理 %d 个专利，之后失败: %v", total, err)
+		}
+		logrus.Infof("已处理 %d 个专利", total)
+	},
+}
+
+var textBatch int
+
+func init() {
+	textBackfillCMD.Flags().IntVarP(&textBatch, "batch", "b", 500, "每批处理的专利数")
+	textCMD.AddCommand(textBackfillCMD)
+}
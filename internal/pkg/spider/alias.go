@@ -0,0 +1,80 @@
+package spider
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"spider/db"
+)
+
+// PublicationAlias 记录任务中的公开号与页面中实际公开号的对应关系
+// 知网有时会把申请公布的页面跳转到同一申请的授权公告，专利以实际的公开号保存
+type PublicationAlias struct {
+	gorm.Model
+
+	Alias         string `gorm:"index:idx_publication_alias,unique;size:32"` // 任务中请求的公开号
+	PublicationNo string `gorm:"index;size:32"`                              // 页面中实际的公开号
+}
+
+// sameApplication 判断请求的公开号与页面中的专利是否属于同一申请
+// 国家代码与序号相同（如 CN112926071A 与 CN112926071B），或请求的公开号在多次公布中
+func sameApplication(requested string, patent *Patent) bool {
+	requestedNumber, err := ParsePublicationNo(requested)
+	if err != nil {
+		return false
+	}
+	if actual, err := ParsePublicationNo(patent.PublicationNo); err == nil &&
+		actual.Country == requestedNumber.Country && actual.Serial == requestedNumber.Serial {
+		return true
+	}
+	for _, publication := range patent.Publications {
+		if publication.PublicationNo == requestedNumber.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolvePublicationNo 返回公开号对应的已保存专利的公开号，是别名时返回实际的公开号，否则原样返回
+func ResolvePublicationNo(publicationNo string) (string, error) {
+	var alias PublicationAlias
+	err := db.GetDB().Where("alias = ?", publicationNo).First(&alias).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return publicationNo, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return alias.PublicationNo, nil
+}
+
+// FindAliases 返回跳转到该专利的所有任务中的公开号
+func FindAliases(publicationNo string) ([]string, error) {
+	var aliases []string
+	err := db.GetDB().Model(&PublicationAlias{}).Where("publication_no = ?", publicationNo).Order("alias").Pluck("alias", &aliases).Error
+	return aliases, err
+}
+
+// LoadHtml 按公开号读取保存的页面，别名先转为实际的公开号
+// 实际的公开号下没有页面时依次尝试别名，WARC 中的请求与之前保存的页面都以任务中的公开号为准
+func LoadHtml(store HtmlStore, publicationNo string) ([]byte, error) {
+	actual, err := ResolvePublicationNo(publicationNo)
+	if err != nil {
+		return nil, err
+	}
+	body, err := store.Load(actual)
+	if !errors.Is(err, ErrHtmlNotFound) {
+		return body, err
+	}
+	aliases, aliasErr := FindAliases(actual)
+	if aliasErr != nil {
+		return nil, aliasErr
+	}
+	for _, alias := range aliases {
+		if body, err := store.Load(alias); !errors.Is(err, ErrHtmlNotFound) {
+			return body, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,73 @@
+package spider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameApplication(t *testing.T) {
+	cases := []struct {
+		requested string
+		patent    Patent
+		want      bool
+	}{
+		{"CN112926071A", Patent{PublicationNo: "CN112926071B"}, true},
+		{"CN112926071A", Patent{PublicationNo: "CN112926072B"}, false},
+		{"US112926071A", Patent{PublicationNo: "CN112926071B"}, false},
+		// 早期的授权公告号与申请公布号的序号不同，只能从多次公布中判断
+		{"CN1234567A", Patent{PublicationNo: "CN100345678C",
+			Publications: []PublicationRecord{{PublicationNo: "CN1234567A"}, {PublicationNo: "CN100345678C"}}}, true},
+		{"CN1234567A", Patent{PublicationNo: "CN100345678C"}, false},
+		{"", Patent{PublicationNo: "CN112926071B"}, false},
+	}
+	for _, c := range cases {
+		if got := sameApplication(c.requested, &c.patent); got != c.want {
+			t.Errorf("sameApplication(%q, %q) = %t, want %t", c.requested, c.patent.PublicationNo, got, c.want)
+		}
+	}
+}
+
+// redirectSource 模拟知网把申请公布跳转到同一申请的授权公告
+type redirectSource struct {
+	fakeSource
+}
+
+func (r *redirectSource) Parse(ctx context.Context, f Fetcher, task *Task, url, body string) (*Patent, error) {
+	patent, err := r.fakeSource.Parse(ctx, f, task, url, body)
+	if err != nil {
+		return nil, err
+	}
+	patent.PublicationNo = "CN113000001B"
+	patent.Aliases = []PublicationAlias{{Alias: task.PublicCode}}
+	return patent, nil
+}
+
+func TestRedirectedHtmlSavedAsActualNo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("授权公告的页面"))
+	}))
+	defer server.Close()
+
+	th := NewFakeTaskHandler()
+	s := newReplaySpider(t, th)
+	s.SetTransport(http.DefaultTransport)
+	s.RegisterSource(&redirectSource{fakeSource{baseURL: server.URL}})
+
+	task := &Task{PublicCode: "CN113000001A", Date: "2021-06-01", Code: "I138", Source: "fake"}
+	task.ID = 1
+	if err := s.Run(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := s.html.Load("CN113000001B"); err != nil || string(body) != "授权公告的页面" {
+		t.Errorf("跳转后的页面应当以实际的公开号保存: %q, %v", body, err)
+	}
+	if _, err := s.html.Load("CN113000001A"); !errors.Is(err, ErrHtmlNotFound) {
+		t.Errorf("跳转后的页面不应以任务中的公开号保存: %v", err)
+	}
+}
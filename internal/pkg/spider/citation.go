@@ -0,0 +1,106 @@
+package spider
+
+import (
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+)
+
+// 引用关系的类型
+const (
+	CitationCited  = "cited"  // 本专利引用的文献
+	CitationCiting = "citing" // 引用本专利的文献
+)
+
+// Citation 是专利与其他文献之间的一条引用关系
+type Citation struct {
+	gorm.Model
+
+	SourcePublicationNo string `gorm:"index:idx_citation,unique;size:32"`  // 本专利的公开号
+	TargetIdentifier    string `gorm:"index:idx_citation,unique;size:255"` // 对方的公开号，非专利文献则为文献的描述
+	Type                string `gorm:"index:idx_citation,unique;size:16"`  // 引用关系的类型：cited、citing
+	TargetTitle         string // 对方的标题，非专利文献为空
+}
+
+// IsPatent 对方是否是能作为任务爬取的专利
+func (c *Citation) IsPatent() bool {
+	return anyPublicationNoReg.FindString(c.TargetIdentifier) == c.TargetIdentifier
+}
+
+// ParseCitations 解析详情页中的引证文献与被引文献
+// 每个列表以标题区分类型，列表项中有公开号的当作专利，否则整行作为文献描述
+func ParseCitations(sourcePublicationNo string, doc *html.Node) ([]Citation, error) {
+	blocks, err := htmlquery.QueryAll(doc, "//div[contains(@class,'citation')]")
+	if err != nil {
+		return nil, err
+	}
+
+	var citations []Citation
+	seen := make(map[string]bool)
+	for _, block := range blocks {
+		titleNode, err := htmlquery.Query(block, ".//h2 | .//h3")
+		if err != nil {
+			return nil, err
+		}
+		if titleNode == nil {
+			continue
+		}
+		citationType := citationTypeOf(htmlquery.InnerText(titleNode))
+		if citationType == "" {
+			continue
+		}
+
+		items, err := htmlquery.QueryAll(block, ".//li")
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			text := joinedText(item)
+			citation := Citation{SourcePublicationNo: sourcePublicationNo, Type: citationType}
+			if publicationNo := anyPublicationNoReg.FindString(text); publicationNo != "" {
+				citation.TargetIdentifier = publicationNo
+				citation.TargetTitle = strings.TrimSpace(strings.Replace(text, publicationNo, "", 1))
+			} else {
+				citation.TargetIdentifier = truncateRunes(text, 255)
+			}
+			if citation.TargetIdentifier == "" || seen[citationType+citation.TargetIdentifier] {
+				continue
+			}
+			seen[citationType+citation.TargetIdentifier] = true
+			citations = append(citations, citation)
+		}
+	}
+	return citations, nil
+}
+
+func citationTypeOf(title string) string {
+	switch {
+	// "被引" 要先判断，"被引文献" 中同样含有 "引"
+	case strings.Contains(title, "被引"):
+		return CitationCiting
+	case strings.Contains(title, "引证"), strings.Contains(title, "参考文献"), strings.Contains(title, "引用"):
+		return CitationCited
+	}
+	return ""
+}
+
+// citedPatentNos 返回引用关系中所有专利的公开号，用于发现新任务
+func citedPatentNos(citations []Citation) []string {
+	var publicationNos []string
+	for _, citation := range citations {
+		if citation.IsPatent() {
+			publicationNos = append(publicationNos, citation.TargetIdentifier)
+		}
+	}
+	return publicationNos
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,165 @@
+package spider
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"spider/db"
+)
+
+var (
+	// 权利要求的编号，如 "1." "2、" "3．"
+	claimNumberReg = regexp.MustCompile(`(\d+)\s*[.、．]`)
+	// 引用的权利要求，如 "根据权利要求1所述"、"如权利要求1至3中任一项所述"、"according to claim 1"、"any one of claims 1-3"
+	claimReferenceReg = regexp.MustCompile(`(?i)(?:权利要求|claims?)\s*(\d+(?:\s*(?:至|到|-|–|~|～|、|,|，|或|和|及|与|or|and|to)\s*\d+)*)`)
+	claimRangeReg     = regexp.MustCompile(`(?i)^(?:至|到|-|–|~|～|to)$`)
+	claimTokenReg     = regexp.MustCompile(`(?i)\d+|至|到|-|–|~|～|to`)
+)
+
+// Claim 是权利要求书中的一项权利要求
+type Claim struct {
+	gorm.Model
+
+	PatentPublicationNo string `gorm:"index:idx_patent_claim,unique;size:32"`
+	Number              int    `gorm:"index:idx_patent_claim,unique"` // 编号，从 1 开始
+	Text                string `gorm:"type:text"`                     // 内容，不含编号
+	DependsOn           string `gorm:"size:255"`                      // 引用的权利要求编号，以逗号分隔，独立权利要求为空
+	Independent         bool   // 是否为独立权利要求
+}
+
+// Dependencies 返回引用的权利要求编号
+func (c Claim) Dependencies() []int {
+	var numbers []int
+	for _, part := range strings.Split(c.DependsOn, ",") {
+		if n, err := strconv.Atoi(part); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// ParseClaims 把权利要求书拆分为编号的权利要求，并解析每项引用的权利要求
+// 编号必须从 1 开始依次递增，避免把"2.5mm"之类的数字当作编号；没有编号时整段作为第 1 项
+func ParseClaims(publicationNo, text string) []Claim {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	var starts, bodies []int
+	next := 1
+	for _, match := range claimNumberReg.FindAllStringSubmatchIndex(text, -1) {
+		number, _ := strconv.Atoi(text[match[2]:match[3]])
+		if number != next || !claimBoundary(text, match[0]) || claimFollowedByDigit(text, match[1]) {
+			continue
+		}
+		starts, bodies = append(starts, match[0]), append(bodies, match[1])
+		next++
+	}
+	if len(starts) == 0 {
+		starts, bodies = []int{0}, []int{0}
+	}
+
+	claims := make([]Claim, 0, len(starts))
+	for i := range starts {
+		end := len(text)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		claim := Claim{
+			PatentPublicationNo: publicationNo,
+			Number:              i + 1,
+			Text:                strings.TrimSpace(text[bodies[i]:end]),
+		}
+		dependencies := claimDependencies(stripMarkup(claim.Text), claim.Number)
+		parts := make([]string, 0, len(dependencies))
+		for _, n := range dependencies {
+			parts = append(parts, strconv.Itoa(n))
+		}
+		claim.DependsOn = strings.Join(parts, ",")
+		claim.Independent = len(dependencies) == 0
+		claims = append(claims, claim)
+	}
+	return claims
+}
+
+// claimBoundary 编号只能出现在开头、换行或句末标点之后
+func claimBoundary(text string, i int) bool {
+	prefix := strings.TrimRight(text[:i], " \t")
+	if prefix == "" || strings.HasSuffix(prefix, "\n") {
+		return true
+	}
+	for _, end := range []string{"。", ".", "；", ";"} {
+		if strings.HasSuffix(prefix, end) {
+			return true
+		}
+	}
+	return false
+}
+
+func claimFollowedByDigit(text string, i int) bool {
+	return i < len(text) && text[i] >= '0' && text[i] <= '9'
+}
+
+// claimDependencies 解析引用的权利要求，只保留编号小于 number 的，去重后排序
+func claimDependencies(text string, number int) []int {
+	seen := make(map[int]bool)
+	for _, match := range claimReferenceReg.FindAllStringSubmatch(text, -1) {
+		tokens := claimTokenReg.FindAllString(match[1], -1)
+		for i := 0; i < len(tokens); i++ {
+			from, err := strconv.Atoi(tokens[i])
+			if err != nil {
+				continue
+			}
+			to := from
+			if i+2 < len(tokens) && claimRangeReg.MatchString(tokens[i+1]) {
+				if n, err := strconv.Atoi(tokens[i+2]); err == nil && n >= from {
+					to = n
+					i += 2
+				}
+			}
+			for n := from; n <= to && n < number; n++ {
+				seen[n] = true
+			}
+		}
+	}
+	numbers := make([]int, 0, len(seen))
+	for n := range seen {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// BackfillClaims 从已保存的主权项拆分权利要求，每批处理 batch 个专利，返回处理的专利数
+func BackfillClaims(batch int) (int, error) {
+	var patents []Patent
+	total := 0
+	err := db.GetDB().
+		Select("id", "publication_no", "sovereignty").
+		FindInBatches(&patents, batch, func(tx *gorm.DB, _ int) error {
+			var claims []Claim
+			for _, patent := range patents {
+				claims = append(claims, ParseClaims(patent.PublicationNo, patent.Sovereignty)...)
+			}
+			if len(claims) > 0 {
+				if err := db.GetDB().Clauses(clause.OnConflict{DoNothing: true}).Create(&claims).Error; err != nil {
+					return err
+				}
+			}
+			total += len(patents)
+			return nil
+		}).Error
+	return total, err
+}
+
+// FindClaims 查询专利的权利要求，按编号排序
+func FindClaims(publicationNo string) ([]Claim, error) {
+	var claims []Claim
+	err := db.GetDB().Where("patent_publication_no = ?", publicationNo).Order("number").Find(&claims).Error
+	return claims, err
+}
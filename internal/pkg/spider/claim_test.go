@@ -0,0 +1,57 @@
+package spider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseClaims(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want []Claim
+	}{
+		{
+			name: "中文",
+			text: "1.一种装置，其特征在于，长度为2.5mm。\n2、根据权利要求1所述的装置，其特征在于，包括壳体。" +
+				"3．根据权利要求1至2中任一项所述的装置。4.一种方法，使用权利要求1或3所述的装置。",
+			want: []Claim{
+				{Number: 1, Text: "一种装置，其特征在于，长度为2.5mm。", Independent: true},
+				{Number: 2, Text: "根据权利要求1所述的装置，其特征在于，包括壳体。", DependsOn: "1"},
+				{Number: 3, Text: "根据权利要求1至2中任一项所述的装置。", DependsOn: "1,2"},
+				{Number: 4, Text: "一种方法，使用权利要求1或3所述的装置。", DependsOn: "1,3"},
+			},
+		},
+		{
+			name: "英文",
+			text: "1. A method comprising: receiving data.\n2. The method of claim 1, wherein the data is text.\n" +
+				"3. The method of any one of claims 1-2, further comprising training.",
+			want: []Claim{
+				{Number: 1, Text: "A method comprising: receiving data.", Independent: true},
+				{Number: 2, Text: "The method of claim 1, wherein the data is text.", DependsOn: "1"},
+				{Number: 3, Text: "The method of any one of claims 1-2, further comprising training.", DependsOn: "1,2"},
+			},
+		},
+		{
+			// 没有编号时整段作为第 1 项，引用自身或之后的编号不算依赖
+			name: "没有编号",
+			text: "一种装置，如权利要求5所述。",
+			want: []Claim{{Number: 1, Text: "一种装置，如权利要求5所述。", Independent: true}},
+		},
+		{name: "空", text: " ", want: nil},
+	}
+	for _, c := range cases {
+		got := ParseClaims("", c.text)
+		if len(got) == 0 && len(c.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", c.name, got, c.want)
+		}
+	}
+
+	claim := Claim{DependsOn: "1,3"}
+	if got := claim.Dependencies(); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("Dependencies() = %v", got)
+	}
+}
@@ -0,0 +1,223 @@
+package spider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/sirupsen/logrus"
+)
+
+const SourceCnki = "cnki"
+
+// CnkiSource 从知网专利库爬取专利
+type CnkiSource struct {
+	rules     atomic.Value  // *RuleSet，字段映射规则，运行中可能被替换
+	rendering TextRendering // 摘要、主权项等字段的渲染方式
+	drift     *DriftMonitor // 规则替换时通知解析异常检测，为空时不通知
+}
+
+func NewCnkiSource() *CnkiSource {
+	c := &CnkiSource{rendering: RenderPlain}
+	c.SetRuleSet(DefaultCnkiRuleSet())
+	return c
+}
+
+// RuleSet 返回当前使用的字段映射规则
+func (c *CnkiSource) RuleSet() *RuleSet {
+	return c.rules.Load().(*RuleSet)
+}
+
+// SetRuleSet 替换字段映射规则，可在运行中调用
+func (c *CnkiSource) SetRuleSet(rs *RuleSet) {
+	c.rules.Store(rs)
+	if c.drift != nil {
+		c.drift.RuleChanged(rs.Version)
+	}
+}
+
+// SetDriftMonitor 设置规则替换时通知的解析异常检测，需在设置规则与开始爬取前调用
+func (c *CnkiSource) SetDriftMonitor(m *DriftMonitor) {
+	c.drift = m
+}
+
+// SetTextRendering 设置摘要、主权项等字段的渲染方式，默认为纯文本，需在开始爬取前调用
+func (c *CnkiSource) SetTextRendering(rendering TextRendering) {
+	c.rendering = rendering
+}
+
+func (c *CnkiSource) Name() string {
+	return SourceCnki
+}
+
+func (c *CnkiSource) URL(task *Task) (string, error) {
+	database, err := GetPatentDatabase(task.DBCode)
+	if err != nil {
+		return "", err
+	}
+	return database.DetailURL(task.PublicCode), nil
+}
+
+func (c *CnkiSource) Fetch(ctx context.Context, f Fetcher, url string) (string, error) {
+	return f.GetHtml(ctx, url)
+}
+
+func (c *CnkiSource) Parse(ctx context.Context, f Fetcher, task *Task, url, body string) (*Patent, error) {
+	database, err := GetPatentDatabase(task.DBCode)
+	if err != nil {
+		return nil, err
+	}
+	date, code, publicCode := task.Date, task.Code, task.PublicCode
+	// 同一个页面的解析过程中只使用同一个版本的规则
+	rules := c.RuleSet()
+
+	patent := &Patent{}
+	patent.DBCode = database.Code
+	patent.ParserVersion = rules.Version
+
+	// 解析 html
+	doc, err := htmlquery.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// 标题、摘要、主权项等直接用 XPath 取值的字段
+	if err := rules.FillXPathFields(patent, database.Code, doc, c.rendering); err != nil {
+		return nil, err
+	}
+
+	// 有的是在row下，有的是在row的row1和row2下，这么写效率最高
+	rows, err := htmlquery.QueryAll(doc, "//div[@class='row'] | //div[@class='row-1'] | //div[@class='row-2']")
+	if err != nil {
+		return nil, err
+	}
+	var rawFields []RawField
+	for _, row := range rows {
+		// 获取 key, 形如"申请号："
+		key, err := htmlquery.Query(row, "./span[@class='rowtit']/text() | ./span[@class='rowtit2']/text()")
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			continue
+		}
+		keyString := strings.TrimSpace(htmlquery.InnerText(key))
+		if keyString == "" {
+			continue
+		}
+
+		// 获取 value
+		valueList, err := htmlquery.QueryAll(row, "./p[@class='funds']//text()")
+		if err != nil {
+			return nil, err
+		}
+		var valueString string
+		for _, value := range valueList {
+			valueString += strings.TrimSpace(htmlquery.InnerText(value))
+		}
+
+		// 根据 key, value 填充 patent，规则中没有的标签也原样保存
+		// 值为空的标签同样记录：可能是知网新增的标签，或值在循环没有读取的元素中；摘要、主权项等由 XPath 规则取值
+		rawFields = append(rawFields, RawField{Label: keyString, Value: valueString})
+		if !rules.FillRowField(patent, database.Code, keyString, valueString) {
+			patent.UnknownLabels = append(patent.UnknownLabels, RawField{Label: keyString, Value: valueString})
+		}
+	}
+	patent.RawFields = encodeRawFields(rawFields)
+
+	// 国外专利只有一个“公开号”，按类型码归入申请公开号或授权公开号
+	if patent.PublicationNo != "" && patent.ApplyPublicationNo == "" && patent.AuthPublicationNo == "" {
+		// 无法解析的号码当作申请公开号，交给后面与任务比对
+		patent.ApplyPublicationNo = patent.PublicationNo
+		if number, err := ParsePublicationNo(patent.PublicationNo); err == nil {
+			if kind := number.KindCategory(); kind != "" && kind != KindApplication {
+				patent.ApplyPublicationNo, patent.AuthPublicationNo = "", patent.PublicationNo
+			}
+		}
+	}
+
+	// 融合申请公开号与授权公开号
+	// 注：其实这个号就是 publicCode，但是有的是申请公开号，有的是授权公开号
+	// 两个都有时优先取与任务一致的那个，都不一致时取授权公开号
+	switch {
+	case patent.ApplyPublicationNo == publicCode, patent.AuthPublicationNo == "":
+		patent.PublicationNo = patent.ApplyPublicationNo
+	default:
+		patent.PublicationNo = patent.AuthPublicationNo
+	}
+	// 与任务不一致时可能是知网跳转到了同一申请的其他阶段，等获取多次公布后再判断
+	mismatchErr := fmt.Errorf("融合申请公开号与授权公开号后，与任务中的公开号匹配失败: "+
+		"日期：%s，学科分类%s，任务中的公开号%s，申请公开号：%s ，授权公开号：%s，融合后的公开号：%s",
+		date, code, publicCode, patent.ApplyPublicationNo, patent.AuthPublicationNo, patent.PublicationNo)
+	if patent.PublicationNo == "" {
+		return nil, mismatchErr
+	}
+
+	// 全文下载链接
+	if patent.FullTextUrl, patent.FullTextFormat, err = ParseFullTextURL(url, doc); err != nil {
+		return nil, err
+	}
+
+	// 引证文献与被引文献
+	citations, err := ParseCitations(patent.PublicationNo, doc)
+	if err != nil {
+		return nil, err
+	}
+	patent.Citations = citations
+
+	// 多次公布，获取失败不影响专利本身的保存
+	publications, err := c.GetMultiPublication(ctx, f, database, patent.PublicationNo)
+	if err != nil {
+		logrus.Warnf("获取多次公布失败: %s, %v", patent.PublicationNo, err)
+	} else if len(publications) > 0 {
+		patent.Publications = publications
+		patent.MultiPublicationNo = joinPublicationNo(publications)
+	}
+
+	// 同一申请的其他阶段以实际的公开号保存，并记录别名
+	if patent.PublicationNo != publicCode {
+		if !sameApplication(publicCode, patent) {
+			return nil, mismatchErr
+		}
+		logrus.Infof("任务中的公开号 %s 跳转到了同一申请的 %s，以 %s 保存", publicCode, patent.PublicationNo, patent.PublicationNo)
+		patent.Aliases = append(patent.Aliases, PublicationAlias{Alias: publicCode})
+	}
+
+	// 法律状态，获取失败同样不影响专利本身的保存
+	events, err := c.GetLegalStatus(ctx, f, database, patent.PublicationNo)
+	if err != nil {
+		logrus.Warnf("获取法律状态失败: %s, %v", patent.PublicationNo, err)
+	} else if len(events) > 0 {
+		patent.LegalStatusEvents = events
+		patent.LegalStatus = CurrentLegalStatus(events)
+	}
+
+	return patent, nil
+}
+
+// GetMultiPublication 请求并解析多次公布，数据库没有多次公布时返回空
+func (c *CnkiSource) GetMultiPublication(ctx context.Context, f Fetcher, database *PatentDatabase, publicationNo string) ([]PublicationRecord, error) {
+	url := database.MultiPublicationURL(publicationNo)
+	if url == "" {
+		return nil, nil
+	}
+	body, err := f.GetSecondaryHtml(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMultiPublication(database, publicationNo, body)
+}
+
+// GetLegalStatus 请求并解析法律状态，数据库没有法律状态时返回空
+func (c *CnkiSource) GetLegalStatus(ctx context.Context, f Fetcher, database *PatentDatabase, publicationNo string) ([]LegalStatusEvent, error) {
+	url := database.LegalStatusURL(publicationNo)
+	if url == "" {
+		return nil, nil
+	}
+	body, err := f.GetSecondaryHtml(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLegalStatus(publicationNo, body)
+}
@@ -0,0 +1,38 @@
+package spider
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCnkiParseOverseasPublicationNo(t *testing.T) {
+	page := func(publicationNo string) string {
+		return `<html><body><div class="doc-top"><h1>Example</h1></div>
+<div class="row"><span class="rowtit">公开号：</span><p class="funds">` + publicationNo + `</p></div>
+<div class="row"><span class="rowtit">同族专利：</span><div class="family">EP3456789A1</div></div>
+</body></html>`
+	}
+	for _, tt := range []struct {
+		no          string
+		apply, auth string
+	}{
+		{"US10956123B2", "", "US10956123B2"},
+		{"EP3456789A1", "EP3456789A1", ""},
+		// 序号超过 12 位，无法解析时当作申请公开号，不能 panic
+		{"KR1020200012345A", "KR1020200012345A", ""},
+	} {
+		task := &Task{PublicCode: tt.no, DBCode: DBCodeSCOD}
+		patent, err := NewCnkiSource().Parse(context.Background(), nil, task, "https://kns.cnki.net/", page(tt.no))
+		if err != nil {
+			t.Errorf("%s: %v", tt.no, err)
+			continue
+		}
+		if patent.PublicationNo != tt.no || patent.ApplyPublicationNo != tt.apply || patent.AuthPublicationNo != tt.auth {
+			t.Errorf("%s: 公开号 %q，申请公开号 %q，授权公开号 %q", tt.no, patent.PublicationNo, patent.ApplyPublicationNo, patent.AuthPublicationNo)
+		}
+		// 值不在 funds 中的新标签同样记录为未识别的标签
+		if len(patent.UnknownLabels) != 1 || patent.UnknownLabels[0] != (RawField{Label: "同族专利："}) {
+			t.Errorf("%s: 未识别的标签 %+v", tt.no, patent.UnknownLabels)
+		}
+	}
+}
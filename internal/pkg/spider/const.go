@@ -7,8 +7,11 @@ const (
 )
 
 var (
-	HtmlDir = filepath.Join(RootDir, "html")
-	LogDir  = filepath.Join(RootDir, "log")
+	HtmlDir        = filepath.Join(RootDir, "html")
+	HtmlArchiveDir = filepath.Join(RootDir, "html_archive")
+	WARCDir        = filepath.Join(RootDir, "warc")
+	FullTextDir    = filepath.Join(RootDir, "fulltext")
+	LogDir         = filepath.Join(RootDir, "log")
 	//LogFile = filepath.Join(LogDir, "spider.log")
 	LogDebugFile = filepath.Join(LogDir, "debug.log")
 	LogInfoFile  = filepath.Join(LogDir, "info.log")
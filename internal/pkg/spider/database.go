@@ -0,0 +1,120 @@
+package spider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 知网的专利数据库代码
+const (
+	DBCodeSCPD = "SCPD" // 中国专利
+	DBCodeSCOD = "SCOD" // 海外专利
+
+	DefaultDBCode = DBCodeSCPD
+)
+
+// 任意国家的公开号，如 CN112926071A、US10123456B2、EP3456789A1、WO2020123456A1
+var anyPublicationNoReg = regexp.MustCompile(`\b[A-Z]{2}\d{4,12}[A-Z]\d?\b`)
+
+// PatentDatabase 描述知网的一个专利数据库：各个页面的地址与公开号的格式
+// 不同数据库页面中的标签不同，见字段映射规则中的 databases
+type PatentDatabase struct {
+	Code string // dbcode
+	Name string
+
+	detailTemplate           string         // 详情页地址
+	multiPublicationTemplate string         // 多次公布地址，为空表示该库没有
+	legalStatusTemplate      string         // 法律状态地址，为空表示该库没有
+	publicationNoReg         *regexp.Regexp // 公开号的格式
+	kindStage                func(kind string) string
+}
+
+var patentDatabases = map[string]*PatentDatabase{
+	DBCodeSCPD: {
+		Code:                     DBCodeSCPD,
+		Name:                     "中国专利",
+		detailTemplate:           "https://kns.cnki.net/kcms/detail/detail.aspx?dbcode=SCPD&filename=%s",
+		multiPublicationTemplate: "https://kns.cnki.net/kcms/detail/frame/multipublish.aspx?dbcode=SCPD&filename=%s",
+		legalStatusTemplate:      "https://kns.cnki.net/kcms/detail/frame/legalstatus.aspx?dbcode=SCPD&filename=%s",
+		publicationNoReg:         regexp.MustCompile(`CN\d{6,9}[A-Z]\d?\b`),
+		kindStage:                cnKindStage,
+	},
+	DBCodeSCOD: {
+		Code:             DBCodeSCOD,
+		Name:             "海外专利",
+		detailTemplate:   "https://kns.cnki.net/kcms/detail/detail.aspx?dbcode=SCOD&filename=%s",
+		publicationNoReg: anyPublicationNoReg,
+		kindStage:        overseasKindStage,
+	},
+}
+
+// GetPatentDatabase 根据 dbcode 获取专利数据库，dbcode 为空时使用中国专利库
+func GetPatentDatabase(dbCode string) (*PatentDatabase, error) {
+	if dbCode == "" {
+		dbCode = DefaultDBCode
+	}
+	database, ok := patentDatabases[strings.ToUpper(dbCode)]
+	if !ok {
+		return nil, fmt.Errorf("不支持的专利数据库: %s", dbCode)
+	}
+	return database, nil
+}
+
+// DBCodeOfPublicationNo 根据公开号的国家代码判断它属于哪个专利数据库
+func DBCodeOfPublicationNo(publicationNo string) string {
+	if strings.HasPrefix(publicationNo, "CN") {
+		return DBCodeSCPD
+	}
+	return DBCodeSCOD
+}
+
+func (d *PatentDatabase) DetailURL(publicCode string) string {
+	return fmt.Sprintf(d.detailTemplate, publicCode)
+}
+
+// MultiPublicationURL 返回多次公布的地址，该库没有多次公布时返回空字符串
+func (d *PatentDatabase) MultiPublicationURL(publicCode string) string {
+	if d.multiPublicationTemplate == "" {
+		return ""
+	}
+	return fmt.Sprintf(d.multiPublicationTemplate, publicCode)
+}
+
+// LegalStatusURL 返回法律状态的地址，该库没有法律状态时返回空字符串
+func (d *PatentDatabase) LegalStatusURL(publicCode string) string {
+	if d.legalStatusTemplate == "" {
+		return ""
+	}
+	return fmt.Sprintf(d.legalStatusTemplate, publicCode)
+}
+
+// Stage 根据公开号的类型码判断公布阶段
+func (d *PatentDatabase) Stage(publicationNo string) string {
+	kind := strings.TrimLeft(publicationNo[2:], "0123456789")
+	return d.kindStage(kind)
+}
+
+// cnKindStage 中国专利的类型码：A 为申请公布，B、C、U、Y、S 为授权公告，带数字的如 A8、B9 为更正
+func cnKindStage(kind string) string {
+	switch {
+	case len(kind) > 1:
+		return StageCorrection
+	case kind == "A":
+		return StageApplyPublication
+	default:
+		return StageAuthPublication
+	}
+}
+
+// overseasKindStage 海外专利的类型码：A、A1、A2 等为申请公布，B、B1、B2 等为授权公告，其余当作更正
+func overseasKindStage(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "A"):
+		return StageApplyPublication
+	case strings.HasPrefix(kind, "B"), strings.HasPrefix(kind, "C"), strings.HasPrefix(kind, "E"):
+		return StageAuthPublication
+	default:
+		return StageCorrection
+	}
+}
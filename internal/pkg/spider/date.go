@@ -0,0 +1,97 @@
+package spider
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"spider/db"
+)
+
+// DateLayout 是日期字符串统一的格式
+const DateLayout = "2006-01-02"
+
+var (
+	// 2021-06-08、2021.6.8、2021/06/08、2021年6月8日、2021-06-08 00:00:00 等
+	separatedDateReg = regexp.MustCompile(`(\d{4})\D{1,3}?(\d{1,2})\D{1,3}?(\d{1,2})`)
+	// 20210608
+	compactDateReg = regexp.MustCompile(`\b(\d{4})(\d{2})(\d{2})\b`)
+)
+
+// ParseDate 解析知网中各种格式的日期，不是合法日期时返回错误
+func ParseDate(str string) (time.Time, error) {
+	match := separatedDateReg.FindStringSubmatch(str)
+	if match == nil {
+		match = compactDateReg.FindStringSubmatch(str)
+	}
+	if match == nil {
+		return time.Time{}, fmt.Errorf("无法解析日期: %q", str)
+	}
+	year, _ := strconv.Atoi(match[1])
+	month, _ := strconv.Atoi(match[2])
+	day, _ := strconv.Atoi(match[3])
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+	// time.Date 会把 2 月 30 日等顺延到下个月，这种日期不合法
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return time.Time{}, fmt.Errorf("日期不合法: %q", str)
+	}
+	return date, nil
+}
+
+// parseDateField 解析日期字符串，成功时把字符串统一为 DateLayout 格式，失败时保留原样
+func parseDateField(str *string) *time.Time {
+	if *str == "" {
+		return nil
+	}
+	date, err := ParseDate(*str)
+	if err != nil {
+		return nil
+	}
+	*str = date.Format(DateLayout)
+	return &date
+}
+
+// NormalizeDates 解析申请日、公开公告日与授权公告日，填充对应的 DATE 列
+// Year 取实际的公开日期的年份，都无法解析时不修改
+func (patent *Patent) NormalizeDates() {
+	patent.ApplicationDay = parseDateField(&patent.ApplicationDate)
+	patent.PublicationDay = parseDateField(&patent.PublicationDate)
+	patent.AuthPublicationDay = parseDateField(&patent.AuthPublicationDate)
+	switch {
+	case patent.PublicationDay != nil:
+		patent.Year = strconv.Itoa(patent.PublicationDay.Year())
+	case patent.AuthPublicationDay != nil:
+		patent.Year = strconv.Itoa(patent.AuthPublicationDay.Year())
+	}
+}
+
+// BackfillDates 为已保存的专利填充日期列并修正 Year，每批处理 batch 个专利，返回处理的专利数
+func BackfillDates(batch int) (int, error) {
+	var patents []Patent
+	total := 0
+	err := db.GetDB().
+		Select("id", "year", "application_date", "publication_date", "auth_publication_date").
+		FindInBatches(&patents, batch, func(tx *gorm.DB, _ int) error {
+			for i := range patents {
+				patent := &patents[i]
+				patent.NormalizeDates()
+				if err := db.GetDB().Model(&Patent{}).Where("id = ?", patent.ID).Updates(map[string]interface{}{
+					"year":                  patent.Year,
+					"application_date":      patent.ApplicationDate,
+					"publication_date":      patent.PublicationDate,
+					"auth_publication_date": patent.AuthPublicationDate,
+					"application_day":       patent.ApplicationDay,
+					"publication_day":       patent.PublicationDay,
+					"auth_publication_day":  patent.AuthPublicationDay,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			total += len(patents)
+			return nil
+		}).Error
+	return total, err
+}
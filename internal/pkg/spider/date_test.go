@@ -0,0 +1,45 @@
+package spider
+
+import "testing"
+
+func TestParseDate(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"2021-06-08", "2021-06-08"},
+		{"2021.6.8", "2021-06-08"},
+		{"2021/06/08", "2021-06-08"},
+		{"2021年6月8日", "2021-06-08"},
+		{" 2021-06-08 00:00:00 ", "2021-06-08"},
+		{"20210608", "2021-06-08"},
+		{"2021-02-30", ""},
+		{"2021-13-01", ""},
+		{"暂无", ""},
+		{"", ""},
+	} {
+		date, err := ParseDate(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseDate(%q) = %s, want error", tt.in, date.Format(DateLayout))
+			}
+			continue
+		}
+		if err != nil || date.Format(DateLayout) != tt.want {
+			t.Errorf("ParseDate(%q) = %s, %v, want %s", tt.in, date.Format(DateLayout), err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDates(t *testing.T) {
+	patent := &Patent{ApplicationDate: "2020年6月2日", AuthPublicationDate: "2021.1.12", PublicationDate: "未知"}
+	patent.NormalizeDates()
+	if patent.ApplicationDate != "2020-06-02" || patent.ApplicationDay == nil || patent.ApplicationDay.Format(DateLayout) != "2020-06-02" {
+		t.Errorf("申请日解析错误: %q, %v", patent.ApplicationDate, patent.ApplicationDay)
+	}
+	if patent.PublicationDate != "未知" || patent.PublicationDay != nil {
+		t.Errorf("无法解析的公开公告日应保留原样: %q, %v", patent.PublicationDate, patent.PublicationDay)
+	}
+	if patent.Year != "2021" {
+		t.Errorf("Year = %q, 应取授权公告日的年份", patent.Year)
+	}
+}
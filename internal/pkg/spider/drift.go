@@ -0,0 +1,382 @@
+package spider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"spider/db"
+)
+
+// 参与填充率统计的字段，基本是 Validate 检查的字段，加上标题、摘要与主权项
+var driftFields = []string{
+	"Title", "ApplicationType", "ApplicationDate", "ApplyPublicationNo", "AuthPublicationNo",
+	"PublicationDate", "AuthPublicationDate", "Applicant", "ApplicantAddress", "Inventors",
+	"ApplicationNO", "AreaCode", "ClassificationNO", "MainClassificationNo", "Agency", "Agent",
+	"Page", "Abstract", "Sovereignty",
+}
+
+// 每个页面都应当有值的字段，自动记录基线时这些字段的填充率都不能低于 MinBaseline
+// 否则解析器很可能在第一次运行时就已经失效，失效时的填充率不能当作基线
+var driftRequiredFields = []string{"Title", "ApplicationDate", "Applicant"}
+
+// 填充率指标，可通过 --metrics 开启的 /debug/vars 查看
+var (
+	driftMetrics      = expvar.NewMap("drift")
+	driftFillRates    = new(expvar.Map).Init() // dbcode.字段 -> 最近一个窗口的填充率
+	driftAlerts       = new(expvar.Int)        // 累计告警次数
+	driftPausedMetric = new(expvar.Int)        // 当前是否因填充率异常暂停，1 为暂停
+)
+
+func init() {
+	driftMetrics.Set("fill_rates", driftFillRates)
+	driftMetrics.Set("alerts", driftAlerts)
+	driftMetrics.Set("paused", driftPausedMetric)
+}
+
+// DriftConfig 是解析异常检测的配置
+type DriftConfig struct {
+	Window       int           // 滚动窗口的大小，即每次统计多少个页面
+	Drop         float64       // 填充率相对基线下降的比例超过它即视为异常，如 0.5 表示下降一半
+	MinBaseline  float64       // 基线填充率低于它的字段本身就经常为空，不检测
+	Pause        time.Duration // 检测到异常后暂停爬取多久，0 表示只告警不暂停
+	Webhook      string        // 告警时 POST 的地址，为空表示不发送
+	BaselineFile string        // 基线保存的文件
+}
+
+func DefaultDriftConfig() DriftConfig {
+	return DriftConfig{
+		Window:       200,
+		Drop:         0.5,
+		MinBaseline:  0.5,
+		Pause:        time.Minute * 30,
+		BaselineFile: filepath.Join(RootDir, "drift_baseline.json"),
+	}
+}
+
+// fillWindow 记录最近 size 个页面中每个字段是否有值
+type fillWindow struct {
+	masks []uint32 // 每个页面一个位图，第 i 位表示 driftFields[i] 是否有值
+	next  int
+	full  bool
+}
+
+func (w *fillWindow) add(mask uint32) {
+	w.masks[w.next] = mask
+	w.next = (w.next + 1) % len(w.masks)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+func (w *fillWindow) rates() map[string]float64 {
+	rates := make(map[string]float64, len(driftFields))
+	for i, field := range driftFields {
+		filled := 0
+		for _, mask := range w.masks {
+			if mask&(1<<i) != 0 {
+				filled++
+			}
+		}
+		rates[field] = float64(filled) / float64(len(w.masks))
+	}
+	return rates
+}
+
+// DriftMonitor 统计每个数据库最近页面的字段填充率，与基线比较
+// 知网改版后字段大面积为空时告警并暂停爬取，避免在解析失败的情况下浪费大量任务
+// 字段映射规则的版本变化时认为问题已修复，重新统计并恢复爬取，见 RuleChanged
+type DriftMonitor struct {
+	cfg DriftConfig
+
+	mu          sync.Mutex
+	windows     map[string]*fillWindow        // dbcode -> 滚动窗口
+	baseline    map[string]map[string]float64 // dbcode -> 字段 -> 填充率
+	version     string                        // 当前统计的规则版本
+	pausedUntil time.Time
+}
+
+func NewDriftMonitor(cfg DriftConfig) *DriftMonitor {
+	if cfg.Window < 10 {
+		cfg.Window = 10
+	}
+	m := &DriftMonitor{
+		cfg:      cfg,
+		windows:  make(map[string]*fillWindow),
+		baseline: make(map[string]map[string]float64),
+	}
+	if cfg.BaselineFile != "" {
+		content, err := os.ReadFile(cfg.BaselineFile)
+		if err == nil {
+			if err := json.Unmarshal(content, &m.baseline); err != nil {
+				logrus.Errorf("读取填充率基线失败，将重新统计: %v", err)
+				m.baseline = make(map[string]map[string]float64)
+			}
+		} else if !os.IsNotExist(err) {
+			logrus.Errorf("读取填充率基线失败，将重新统计: %v", err)
+		}
+	}
+	return m
+}
+
+// fillMask 返回专利的字段填充位图，第 i 位表示 driftFields[i] 是否有值
+func fillMask(patent *Patent) uint32 {
+	var mask uint32
+	value := reflect.ValueOf(patent).Elem()
+	for i, field := range driftFields {
+		if value.FieldByName(field).String() != "" {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+// Observe 记录一个解析后的专利，应在校验前调用，这样被校验拒绝的页面也会计入
+func (m *DriftMonitor) Observe(patent *Patent) {
+	mask := fillMask(patent)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 规则换了版本，之前的统计不再有意义
+	if patent.ParserVersion != m.version {
+		m.reset(patent.ParserVersion)
+	}
+	m.add(patent.DBCode, mask)
+}
+
+// ObserveFailure 记录一个解析失败的页面，视为所有字段都为空
+// 知网改版后页面往往直接解析失败，而不是部分字段为空
+func (m *DriftMonitor) ObserveFailure(dbCode string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add(dbCode, 0)
+}
+
+// RuleChanged 在字段映射规则被替换后调用，版本变化时重新统计并恢复爬取
+// 暂停期间没有页面被解析，只能由规则的推送来恢复
+func (m *DriftMonitor) RuleChanged(version string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if version != m.version {
+		m.reset(version)
+	}
+}
+
+func (m *DriftMonitor) reset(version string) {
+	if m.version != "" {
+		logrus.Infof("字段映射规则版本变为 %s，重新统计填充率", version)
+		m.resume()
+	}
+	m.version = version
+	m.windows = make(map[string]*fillWindow)
+}
+
+// add 把一个页面加入 dbCode 的窗口，窗口满后与基线比较
+func (m *DriftMonitor) add(dbCode string, mask uint32) {
+	window, ok := m.windows[dbCode]
+	if !ok {
+		window = &fillWindow{masks: make([]uint32, m.cfg.Window)}
+		m.windows[dbCode] = window
+	}
+	window.add(mask)
+	if !window.full {
+		return
+	}
+
+	rates := window.rates()
+	for field, rate := range rates {
+		driftFillRates.Set(dbCode+"."+field, expvarFloat(rate))
+	}
+	baseline, ok := m.baseline[dbCode]
+	if !ok {
+		if low := m.lowRequiredFields(rates); len(low) > 0 {
+			logrus.Errorf("%s 最近 %d 个页面中 %s 的填充率低于 %.0f%%，解析可能已经失效，不作为基线。"+
+				"确认解析正常后可用 drift baseline 从已保存的专利生成基线",
+				dbCode, m.cfg.Window, strings.Join(low, "、"), m.cfg.MinBaseline*100)
+			delete(m.windows, dbCode)
+			return
+		}
+		m.setBaseline(dbCode, rates)
+		return
+	}
+	if dropped := m.droppedFields(baseline, rates); len(dropped) > 0 {
+		m.alert(dbCode, dropped, baseline, rates)
+		// 重新统计，暂停结束后用新的窗口判断是否恢复正常
+		delete(m.windows, dbCode)
+	}
+}
+
+// droppedFields 返回填充率相对基线大幅下降的字段
+func (m *DriftMonitor) droppedFields(baseline, rates map[string]float64) []string {
+	var dropped []string
+	for field, base := range baseline {
+		if base < m.cfg.MinBaseline {
+			continue
+		}
+		if rates[field] < base*(1-m.cfg.Drop) {
+			dropped = append(dropped, field)
+		}
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+// lowRequiredFields 返回填充率低于 MinBaseline 的必填字段
+func (m *DriftMonitor) lowRequiredFields(rates map[string]float64) []string {
+	var low []string
+	for _, field := range driftRequiredFields {
+		if rates[field] < m.cfg.MinBaseline {
+			low = append(low, field)
+		}
+	}
+	return low
+}
+
+func (m *DriftMonitor) setBaseline(dbCode string, rates map[string]float64) {
+	m.baseline[dbCode] = rates
+	logrus.Infof("已记录 %s 的字段填充率基线", dbCode)
+	if m.cfg.BaselineFile == "" {
+		return
+	}
+	if err := SaveDriftBaseline(m.cfg.BaselineFile, m.baseline); err != nil {
+		logrus.Errorf("保存填充率基线失败: %v", err)
+	}
+}
+
+// SaveDriftBaseline 把每个数据库的字段填充率基线保存到文件
+func SaveDriftBaseline(file string, baseline map[string]map[string]float64) error {
+	content, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0644)
+}
+
+// ComputeDriftBaseline 用每个数据库最近保存的 limit 个专利计算字段填充率，作为基线
+// parserVersion 不为空时只统计该版本的规则解析的专利，即一次确认解析正常的爬取
+func ComputeDriftBaseline(parserVersion string, limit int) (map[string]map[string]float64, error) {
+	baseline := make(map[string]map[string]float64)
+	for dbCode := range patentDatabases {
+		query := db.GetDB().Where("db_code = ?", dbCode)
+		if parserVersion != "" {
+			query = query.Where("parser_version = ?", parserVersion)
+		}
+		var patents []Patent
+		if err := query.Order("id DESC").Limit(limit).Find(&patents).Error; err != nil {
+			return nil, err
+		}
+		if len(patents) == 0 {
+			continue
+		}
+		window := &fillWindow{masks: make([]uint32, 0, len(patents))}
+		for i := range patents {
+			window.masks = append(window.masks, fillMask(&patents[i]))
+		}
+		baseline[dbCode] = window.rates()
+	}
+	return baseline, nil
+}
+
+func (m *DriftMonitor) alert(dbCode string, dropped []string, baseline, rates map[string]float64) {
+	details := make([]string, 0, len(dropped))
+	for _, field := range dropped {
+		details = append(details, fmt.Sprintf("%s %.0f%% -> %.0f%%", field, baseline[field]*100, rates[field]*100))
+	}
+	message := fmt.Sprintf("解析异常：%s 最近 %d 个页面中以下字段的填充率大幅下降，知网可能已改版：%s",
+		dbCode, m.cfg.Window, strings.Join(details, "，"))
+	if m.cfg.Pause > 0 {
+		m.pausedUntil = time.Now().Add(m.cfg.Pause)
+		driftPausedMetric.Set(1)
+		message += fmt.Sprintf("。爬取暂停 %s，修复字段映射规则并推送后会自动恢复", m.cfg.Pause)
+	}
+	logrus.Error(message)
+	driftAlerts.Add(1)
+
+	if m.cfg.Webhook != "" {
+		go sendDriftWebhook(m.cfg.Webhook, message, dbCode, dropped)
+	}
+}
+
+func (m *DriftMonitor) resume() {
+	if !m.pausedUntil.IsZero() {
+		logrus.Info("恢复爬取")
+	}
+	m.pausedUntil = time.Time{}
+	driftPausedMetric.Set(0)
+}
+
+// Paused 返回当前是否因为解析异常暂停爬取
+func (m *DriftMonitor) Paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pausedUntil.IsZero() {
+		return false
+	}
+	if time.Now().After(m.pausedUntil) {
+		m.resume()
+		return false
+	}
+	return true
+}
+
+// WaitIfPaused 暂停期间阻塞，直到恢复或 ctx 结束
+func (m *DriftMonitor) WaitIfPaused(ctx context.Context) {
+	for m.Paused() && ctx.Err() == nil {
+		sleepWithContext(ctx, time.Second*10)
+	}
+}
+
+func sendDriftWebhook(webhook, message, dbCode string, fields []string) {
+	content, err := json.Marshal(map[string]interface{}{
+		"text":    message,
+		"dbcode":  dbCode,
+		"fields":  fields,
+		"time":    time.Now().Format(time.RFC3339),
+		"msgtype": "text",
+	})
+	if err != nil {
+		logrus.Errorf("发送解析异常告警失败: %v", err)
+		return
+	}
+	client := &http.Client{Timeout: time.Second * 10}
+	res, err := client.Post(webhook, "application/json", bytes.NewReader(content))
+	if err != nil {
+		logrus.Errorf("发送解析异常告警失败: %v", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		logrus.Errorf("发送解析异常告警失败，状态码 %d", res.StatusCode)
+	}
+}
+
+// SetDrift 开启解析异常检测
+func (s *Spider) SetDrift(cfg DriftConfig) {
+	s.drift = NewDriftMonitor(cfg)
+}
+
+// Drift 返回解析异常检测，未开启时为空
+func (s *Spider) Drift() *DriftMonitor {
+	return s.drift
+}
+
+type expvarFloat float64
+
+func (f expvarFloat) String() string {
+	return fmt.Sprintf("%.4f", float64(f))
+}
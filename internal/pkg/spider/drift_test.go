@@ -0,0 +1,126 @@
+package spider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func driftPatent(version string, applicant string) *Patent {
+	return &Patent{
+		DBCode:          DBCodeSCPD,
+		ParserVersion:   version,
+		Title:           "一种神经网络的训练方法",
+		ApplicationType: "发明公开",
+		ApplicationDate: "2021-03-01",
+		PublicationNo:   "CN112926071A",
+		Applicant:       applicant,
+		Inventors:       "张三;李四",
+		Abstract:        "摘要",
+	}
+}
+
+func TestDriftMonitor(t *testing.T) {
+	alerts := make(chan map[string]interface{}, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		alerts <- body
+	}))
+	defer webhook.Close()
+
+	baselineFile := filepath.Join(t.TempDir(), "baseline.json")
+	cfg := DriftConfig{Window: 10, Drop: 0.5, MinBaseline: 0.5, Pause: time.Hour, Webhook: webhook.URL, BaselineFile: baselineFile}
+	m := NewDriftMonitor(cfg)
+
+	// 第一个完整的窗口作为基线
+	for i := 0; i < 10; i++ {
+		m.Observe(driftPatent("v1", "某某公司"))
+	}
+	if m.Paused() {
+		t.Fatal("记录基线时不应暂停")
+	}
+
+	// 改版后申请人全部为空
+	for i := 0; i < 10; i++ {
+		m.Observe(driftPatent("v1", ""))
+	}
+	if !m.Paused() {
+		t.Fatal("申请人的填充率从 100% 降到 0%，应当暂停")
+	}
+	select {
+	case body := <-alerts:
+		fields, _ := body["fields"].([]interface{})
+		if len(fields) != 1 || fields[0] != "Applicant" {
+			t.Errorf("告警中的字段不正确: %v", body["fields"])
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("没有收到告警")
+	}
+
+	// 基线已保存，重启后不需要重新统计
+	if restarted := NewDriftMonitor(cfg); restarted.baseline[DBCodeSCPD]["Applicant"] != 1 {
+		t.Errorf("基线没有保存: %v", restarted.baseline)
+	}
+
+	// 推送新版本的规则后恢复
+	m.Observe(driftPatent("v2", "某某公司"))
+	if m.Paused() {
+		t.Error("规则版本变化后应当恢复爬取")
+	}
+}
+
+func TestDriftResumeOnRulePush(t *testing.T) {
+	m := NewDriftMonitor(DriftConfig{Window: 10, Drop: 0.5, MinBaseline: 0.5, Pause: time.Hour})
+	cnki := NewCnkiSource()
+	cnki.SetDriftMonitor(m)
+	version := cnki.RuleSet().Version
+	cnki.SetRuleSet(cnki.RuleSet())
+
+	for i := 0; i < 10; i++ {
+		m.Observe(driftPatent(version, "某某公司"))
+	}
+	// 改版后页面直接解析失败，同样视为字段为空
+	for i := 0; i < 10; i++ {
+		m.ObserveFailure(DBCodeSCPD)
+	}
+	if !m.Paused() {
+		t.Fatal("解析失败的页面应当计入填充率并暂停")
+	}
+
+	// 暂停期间没有页面被解析，推送规则后直接恢复
+	rs, err := ParseRuleSet(DefaultCnkiRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Version = version + "-fix"
+	cnki.SetRuleSet(rs)
+	if m.Paused() {
+		t.Error("推送新版本的规则后应当恢复爬取")
+	}
+	if len(m.windows) != 0 {
+		t.Error("推送新版本的规则后应当重新统计")
+	}
+}
+
+func TestDriftBaselineRejectsLowRates(t *testing.T) {
+	m := NewDriftMonitor(DriftConfig{Window: 10, Drop: 0.5, MinBaseline: 0.5, Pause: time.Hour})
+	// 第一次运行时解析器就已失效，申请人全部为空
+	for i := 0; i < 10; i++ {
+		m.Observe(driftPatent("v1", ""))
+	}
+	if _, ok := m.baseline[DBCodeSCPD]; ok {
+		t.Fatal("必填字段的填充率过低时不应作为基线")
+	}
+	for i := 0; i < 10; i++ {
+		m.Observe(driftPatent("v1", "某某公司"))
+	}
+	if m.baseline[DBCodeSCPD]["Applicant"] != 1 {
+		t.Errorf("解析正常后应当记录基线: %v", m.baseline)
+	}
+}
@@ -0,0 +1,78 @@
+package spider
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// PatentDuplicate 记录保存时公开号已经存在的专利，没有开启更新模式时已保存的版本不会被覆盖
+// 多个任务跳转到同一个专利，或任务重复时都会出现
+type PatentDuplicate struct {
+	gorm.Model
+
+	TaskID                uint   `gorm:"index"`
+	PublicationNo         string `gorm:"index;size:32"`
+	ExistingParserVersion string // 已保存版本的解析器版本
+	ParserVersion         string // 本次爬取的解析器版本
+	Fields                string `gorm:"type:text"` // 与已保存版本不同的列，以逗号分隔，完全相同时为空
+}
+
+// FieldDiff 是一个列在两个版本中的值
+type FieldDiff struct {
+	Column string
+	Old    string
+	New    string
+}
+
+// upsertFields 返回直接由页面得到、更新时可以覆盖的列：规范化的文本字段、全文链接与原始标签
+func (patent *Patent) upsertFields() []textField {
+	return append(patent.textFields(),
+		textField{column: "full_text_url", value: &patent.FullTextUrl},
+		textField{column: "full_text_format", value: &patent.FullTextFormat},
+		textField{column: "raw_fields", value: &patent.RawFields},
+	)
+}
+
+// comparedFields 返回比较两个版本时使用的列：upsertFields，以及由它们解析得到的列
+// 全文的本地路径、大小与校验和在下载后单独更新，不参与比较
+func (patent *Patent) comparedFields() []textField {
+	return append(patent.upsertFields(),
+		textField{column: "province", value: &patent.Province},
+		textField{column: "city", value: &patent.City},
+		textField{column: "district", value: &patent.District},
+		textField{column: "publication_country", value: &patent.PublicationCountry},
+		textField{column: "publication_serial", value: &patent.PublicationSerial},
+		textField{column: "publication_kind", value: &patent.PublicationKind},
+		textField{column: "kind_category", value: &patent.KindCategory},
+		textField{column: "application_key", value: &patent.ApplicationKey},
+	)
+}
+
+// diffPatents 比较两个版本的专利，返回值不同的列
+func diffPatents(old, new *Patent) []FieldDiff {
+	var diffs []FieldDiff
+	newFields := new.comparedFields()
+	for i, field := range old.comparedFields() {
+		if *field.value != *newFields[i].value {
+			diffs = append(diffs, FieldDiff{Column: field.column, Old: *field.value, New: *newFields[i].value})
+		}
+	}
+	return diffs
+}
+
+// NewPatentDuplicate 比较已保存的专利与本次爬取的专利
+func NewPatentDuplicate(taskID uint, existing, patent *Patent) *PatentDuplicate {
+	diffs := diffPatents(existing, patent)
+	columns := make([]string, 0, len(diffs))
+	for _, diff := range diffs {
+		columns = append(columns, diff.Column)
+	}
+	return &PatentDuplicate{
+		TaskID:                taskID,
+		PublicationNo:         patent.PublicationNo,
+		ExistingParserVersion: existing.ParserVersion,
+		ParserVersion:         patent.ParserVersion,
+		Fields:                strings.Join(columns, ","),
+	}
+}
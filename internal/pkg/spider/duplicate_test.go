@@ -0,0 +1,52 @@
+package spider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSavePatentErrors(t *testing.T) {
+	title := "第一次爬取的标题"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(title))
+	}))
+	defer server.Close()
+
+	th := NewFakeTaskHandler()
+	s := newReplaySpider(t, th)
+	s.SetTransport(http.DefaultTransport)
+	s.RegisterSource(&fakeSource{baseURL: server.URL})
+	newTask := func(id uint) *Task {
+		task := &Task{PublicCode: "CN113000001A", Date: "2021-06-01", Code: "I138", Source: "fake"}
+		task.ID = id
+		return task
+	}
+
+	// 保存失败时错误返回给 worker，而不是只记录日志
+	th.SaveErr = errors.New("数据库连接断开")
+	if err := s.Run(context.Background(), newTask(1)); !errors.Is(err, th.SaveErr) {
+		t.Fatalf("保存失败时 Run 返回 %v", err)
+	}
+	th.SaveErr = nil
+	if err := s.Run(context.Background(), newTask(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 公开号已经存在时不覆盖，记录不同的列
+	title = "第二次爬取的标题"
+	if err := s.Run(context.Background(), newTask(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if th.SavedPatents[1].Title != "第一次爬取的标题" || th.SavedPatents[2] != nil {
+		t.Errorf("已保存的专利被覆盖: %v", th.SavedPatents)
+	}
+	if duplicate := th.Duplicates[2]; duplicate == nil || duplicate.Fields != "title" {
+		t.Errorf("重复的专利记录错误: %+v", duplicate)
+	}
+}
@@ -0,0 +1,188 @@
+package spider
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"spider/db"
+)
+
+const (
+	EntityPerson       = "person"       // 自然人
+	EntityOrganization = "organization" // 公司、高校等机构
+)
+
+const (
+	RoleInventor  = "inventor"  // 发明人
+	RoleApplicant = "applicant" // 申请人
+	RoleAgent     = "agent"     // 代理人
+	RoleAgency    = "agency"    // 代理机构
+)
+
+// 名称中包含这些词的申请人视为机构，其余视为自然人
+var organizationKeywords = []string{
+	"公司", "大学", "学院", "学校", "研究所", "研究院", "研究中心", "实验室", "医院", "中心", "集团", "工厂", "厂",
+	"事务所", "委员会", "协会", "银行", "局", "株式会社", "会社",
+}
+
+// organizationWords 是机构名称中的英文单词，只按整个单词匹配，避免 VINCENT 中的 INC 这样的误判
+var organizationWords = map[string]bool{
+	"INC": true, "INCORPORATED": true, "LLC": true, "LTD": true, "LIMITED": true, "CORP": true, "CORPORATION": true,
+	"COMPANY": true, "GMBH": true, "UNIVERSITY": true, "INSTITUTE": true,
+}
+
+// Entity 是发明人、申请人、代理人等，同名同类型的视为同一个
+type Entity struct {
+	gorm.Model
+
+	Name string `gorm:"index:idx_entity_name_kind,unique;size:255"`
+	Kind string `gorm:"index:idx_entity_name_kind,unique;size:16"` // person、organization
+}
+
+// PatentEntity 是专利与 Entity 的关联
+type PatentEntity struct {
+	gorm.Model
+
+	PatentPublicationNo string `gorm:"index:idx_patent_entity,unique;size:32"`
+	Role                string `gorm:"index:idx_patent_entity,unique;size:16"` // inventor、applicant、agent、agency
+	Position            int    `gorm:"index:idx_patent_entity,unique"`         // 在原始字符串中的顺序，从 1 开始
+	EntityID            uint   `gorm:"index"`
+
+	Entity Entity `gorm:"-"`
+}
+
+// PatentEntities 从专利的发明人、申请人、代理人与代理机构中拆分出所有 Entity
+// 返回的 PatentEntity 中 EntityID 为空，由 SaveEntities 填充
+func PatentEntities(patent *Patent) []PatentEntity {
+	var entities []PatentEntity
+	add := func(raw, role string, kind func(string) string) {
+		for i, name := range splitNames(raw) {
+			entities = append(entities, PatentEntity{
+				PatentPublicationNo: patent.PublicationNo,
+				Role:                role,
+				Position:            i + 1,
+				Entity:              Entity{Name: name, Kind: kind(name)},
+			})
+		}
+	}
+	person := func(string) string { return EntityPerson }
+	organization := func(string) string { return EntityOrganization }
+	add(patent.Inventors, RoleInventor, person)
+	add(patent.Applicant, RoleApplicant, entityKind)
+	add(patent.Agent, RoleAgent, person)
+	add(patent.Agency, RoleAgency, organization)
+	return entities
+}
+
+// splitNames 按分号拆分名称，去掉空白与重复的名称，保留原始顺序
+func splitNames(raw string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.FieldsFunc(raw, func(r rune) bool { return r == ';' || r == '；' }) {
+		name = truncateRunes(strings.TrimSpace(name), 255)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// entityKind 根据名称判断申请人是自然人还是机构
+// 中文关键词按子串匹配，英文关键词按空格、逗号与句点拆分后的单词匹配
+func entityKind(name string) string {
+	for _, keyword := range organizationKeywords {
+		if strings.Contains(name, keyword) {
+			return EntityOrganization
+		}
+	}
+	words := strings.FieldsFunc(strings.ToUpper(name), func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == '.'
+	})
+	for _, word := range words {
+		if organizationWords[word] {
+			return EntityOrganization
+		}
+	}
+	return EntityPerson
+}
+
+// SaveEntities 保存一个或多个专利的所有 Entity 及关联，已存在的 Entity 与关联不会重复保存
+func SaveEntities(tx *gorm.DB, patents ...*Patent) error {
+	var links []PatentEntity
+	for _, patent := range patents {
+		links = append(links, PatentEntities(patent)...)
+	}
+	if len(links) == 0 {
+		return nil
+	}
+
+	entities := make([]Entity, 0, len(links))
+	for _, link := range links {
+		entities = append(entities, link.Entity)
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entities).Error; err != nil {
+		return err
+	}
+	// 插入时忽略了已存在的 Entity，拿不到 id，按名称与类型逐个重新查询
+	// 由数据库比较名称：按 MySQL 的排序规则，大小写或全半角不同的名称是同一个 Entity，Go 中按字节比较会找不到
+	ids := make(map[[2]string]uint, len(links))
+	for i := range links {
+		key := [2]string{links[i].Entity.Name, links[i].Entity.Kind}
+		id, ok := ids[key]
+		if !ok {
+			var saved Entity
+			if err := tx.Where("name = ? AND kind = ?", key[0], key[1]).Take(&saved).Error; err != nil {
+				return fmt.Errorf("查询 Entity 失败: %s, %s, %w", key[0], key[1], err)
+			}
+			id = saved.ID
+			ids[key] = id
+		}
+		links[i].EntityID = id
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+}
+
+// BackfillEntities 为已保存的专利重新拆分并关联 Entity，每批处理 batch 个专利，返回处理的专利数
+// 专利原有的关联先删除，自然人与机构的判断规则修改后，重新执行即可修正之前的关联
+func BackfillEntities(batch int) (int, error) {
+	var patents []Patent
+	total := 0
+	err := db.GetDB().
+		Select("id", "publication_no", "inventors", "applicant", "agent", "agency").
+		FindInBatches(&patents, batch, func(tx *gorm.DB, _ int) error {
+			for i := range patents {
+				err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+					if err := tx.Unscoped().Where("patent_publication_no = ?", patents[i].PublicationNo).Delete(&PatentEntity{}).Error; err != nil {
+						return err
+					}
+					return SaveEntities(tx, &patents[i])
+				})
+				if err != nil {
+					return err
+				}
+			}
+			total += len(patents)
+			return nil
+		}).Error
+	return total, err
+}
+
+// FindPatentsByEntity 查询某个发明人、申请人等的所有专利，role 为空时不限角色
+func FindPatentsByEntity(name, role string) ([]Patent, error) {
+	query := db.GetDB().
+		Joins("JOIN patent_entities ON patent_entities.patent_publication_no = patents.publication_no AND patent_entities.deleted_at IS NULL").
+		Joins("JOIN entities ON entities.id = patent_entities.entity_id").
+		Where("entities.name = ?", name)
+	if role != "" {
+		query = query.Where("patent_entities.role = ?", role)
+	}
+	var patents []Patent
+	err := query.Distinct("patents.*").Order("patents.id").Find(&patents).Error
+	return patents, err
+}
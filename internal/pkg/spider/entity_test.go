@@ -0,0 +1,53 @@
+package spider
+
+import "testing"
+
+func TestPatentEntities(t *testing.T) {
+	patent := &Patent{
+		PublicationNo: "CN112926071A",
+		Inventors:     "张三;李四； 王五;张三",
+		Applicant:     "某某科技有限公司;清华大学;赵六",
+		Agent:         "钱七",
+		Agency:        "北京某某知识产权代理有限公司 11100",
+	}
+	want := []PatentEntity{
+		{Role: RoleInventor, Position: 1, Entity: Entity{Name: "张三", Kind: EntityPerson}},
+		{Role: RoleInventor, Position: 2, Entity: Entity{Name: "李四", Kind: EntityPerson}},
+		{Role: RoleInventor, Position: 3, Entity: Entity{Name: "王五", Kind: EntityPerson}},
+		{Role: RoleApplicant, Position: 1, Entity: Entity{Name: "某某科技有限公司", Kind: EntityOrganization}},
+		{Role: RoleApplicant, Position: 2, Entity: Entity{Name: "清华大学", Kind: EntityOrganization}},
+		{Role: RoleApplicant, Position: 3, Entity: Entity{Name: "赵六", Kind: EntityPerson}},
+		{Role: RoleAgent, Position: 1, Entity: Entity{Name: "钱七", Kind: EntityPerson}},
+		{Role: RoleAgency, Position: 1, Entity: Entity{Name: "北京某某知识产权代理有限公司 11100", Kind: EntityOrganization}},
+	}
+	got := PatentEntities(patent)
+	if len(got) != len(want) {
+		t.Fatalf("拆分出 %d 个，应为 %d 个: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].PatentPublicationNo != patent.PublicationNo || got[i].Role != want[i].Role ||
+			got[i].Position != want[i].Position || got[i].Entity != want[i].Entity {
+			t.Errorf("第 %d 个为 %+v，应为 %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEntityKind(t *testing.T) {
+	cases := map[string]string{
+		"VINCENT LEE":            EntityPerson,
+		"Maria Corpuz":           EntityPerson,
+		"Ltdova Anna":            EntityPerson,
+		"Apple Inc.":             EntityOrganization,
+		"SONY GROUP CORPORATION": EntityOrganization,
+		"Siemens GmbH":           EntityOrganization,
+		"Foo Co.,Ltd.":           EntityOrganization,
+		"Stanford University":    EntityOrganization,
+		"某某科技有限公司":               EntityOrganization,
+		"赵六":                     EntityPerson,
+	}
+	for name, want := range cases {
+		if got := entityKind(name); got != want {
+			t.Errorf("entityKind(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
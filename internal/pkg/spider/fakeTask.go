@@ -1,9 +1,23 @@
 package spider
 
-import "math/rand"
+import (
+	"math/rand"
+	"sync"
+)
 
 type FakeTaskHandler struct {
 	CallNumOfRandomBatchTasks int
+	ReturnedTasks             []Task
+	DiscoveredTasks           []string
+	UnknownLabels             map[string]int64
+
+	mu                sync.Mutex
+	SavedPatents      map[uint]*Patent            // taskID -> 保存的专利
+	ValidationReports map[uint]*ValidationReport  // taskID -> 校验报告
+	Quarantined       map[uint]*QuarantinedPatent // taskID -> 隔离的专利
+	Duplicates        map[uint]*PatentDuplicate   // taskID -> 公开号已经存在的专利
+	SaveErr           error                       // 不为空时 SavePatent 返回该错误，模拟数据库失败
+	SavedBatches      []int                       // 每次 SavePatents 的专利数
 }
 
 func NewFakeTaskHandler() *FakeTaskHandler {
@@ -31,6 +45,105 @@ func (f *FakeTaskHandler) RandomBatchTasks(num int) ([]Task, error) {
 	return tasks, nil
 }
 
-func (f *FakeTaskHandler) SavePatent(_ uint, _ *Patent) error {
+func (f *FakeTaskHandler) SavePatent(taskID uint, patent *Patent, report *ValidationReport) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.SaveErr != nil {
+		return f.SaveErr
+	}
+	if report != nil {
+		if f.ValidationReports == nil {
+			f.ValidationReports = make(map[uint]*ValidationReport)
+		}
+		f.ValidationReports[taskID] = report
+	}
+	for _, existing := range f.SavedPatents {
+		if existing.PublicationNo == patent.PublicationNo {
+			if f.Duplicates == nil {
+				f.Duplicates = make(map[uint]*PatentDuplicate)
+			}
+			f.Duplicates[taskID] = NewPatentDuplicate(taskID, existing, patent)
+			return nil
+		}
+	}
+	if f.SavedPatents == nil {
+		f.SavedPatents = make(map[uint]*Patent)
+	}
+	f.SavedPatents[taskID] = patent
+	return nil
+}
+
+func (f *FakeTaskHandler) SavePatents(batch []PendingPatent) error {
+	f.mu.Lock()
+	f.SavedBatches = append(f.SavedBatches, len(batch))
+	f.mu.Unlock()
+	for _, item := range batch {
+		if err := f.SavePatent(item.TaskID, item.Patent, item.Report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FakeTaskHandler) ReturnTasks(tasks []Task) error {
+	f.ReturnedTasks = append(f.ReturnedTasks, tasks...)
+	return nil
+}
+
+func (f *FakeTaskHandler) DiscoverTasks(publicCodes []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.DiscoveredTasks = append(f.DiscoveredTasks, publicCodes...)
+	return nil
+}
+
+func (f *FakeTaskHandler) SaveUnknownLabels(labels []UnknownLabel) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.UnknownLabels == nil {
+		f.UnknownLabels = make(map[string]int64)
+	}
+	for _, label := range labels {
+		f.UnknownLabels[label.Label] += label.Occurrences
+	}
+	return nil
+}
+
+func (f *FakeTaskHandler) SaveValidationReport(taskID uint, report *ValidationReport) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ValidationReports == nil {
+		f.ValidationReports = make(map[uint]*ValidationReport)
+	}
+	f.ValidationReports[taskID] = report
+	return nil
+}
+
+func (f *FakeTaskHandler) QuarantinePatent(taskID uint, patent *Patent, report *ValidationReport) error {
+	quarantined, err := NewQuarantinedPatent(taskID, patent, report)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ValidationReports == nil {
+		f.ValidationReports = make(map[uint]*ValidationReport)
+	}
+	f.ValidationReports[taskID] = report
+	if f.Quarantined == nil {
+		f.Quarantined = make(map[uint]*QuarantinedPatent)
+	}
+	f.Quarantined[taskID] = quarantined
+	return nil
+}
+
+func (f *FakeTaskHandler) SaveFullText(publicationNo, path string, size int64, sha256 string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, patent := range f.SavedPatents {
+		if patent.PublicationNo == publicationNo {
+			patent.FullTextPath, patent.FullTextSize, patent.FullTextSha256 = path, size, sha256
+		}
+	}
 	return nil
 }
@@ -0,0 +1,112 @@
+package spider
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 录制的请求保存为两个文件：<key>.json 保存请求与响应头，<key>.body 保存响应体
+const (
+	fixtureMetaExt = ".json"
+	fixtureBodyExt = ".body"
+)
+
+// Fixture 是一次录制下来的请求与响应
+type Fixture struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"-"`
+}
+
+// fixtureKey 根据请求方法与 url 生成录制文件名
+func fixtureKey(method, url string) string {
+	sum := sha1.Sum([]byte(method + " " + url))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// SaveFixture 把录制的请求保存到 dir 中
+func SaveFixture(dir string, fixture *Fixture) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	meta, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	key := fixtureKey(fixture.Method, fixture.URL)
+	if err := os.WriteFile(filepath.Join(dir, key+fixtureBodyExt), fixture.Body, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, key+fixtureMetaExt), meta, 0644)
+}
+
+// LoadFixtures 读取 dir 中所有录制的请求
+func LoadFixtures(dir string) ([]*Fixture, error) {
+	metaFiles, err := filepath.Glob(filepath.Join(dir, "*"+fixtureMetaExt))
+	if err != nil {
+		return nil, err
+	}
+	fixtures := make([]*Fixture, 0, len(metaFiles))
+	for _, metaFile := range metaFiles {
+		meta, err := os.ReadFile(metaFile)
+		if err != nil {
+			return nil, err
+		}
+		fixture := &Fixture{}
+		if err := json.Unmarshal(meta, fixture); err != nil {
+			return nil, fmt.Errorf("解析录制文件 %s 失败: %w", metaFile, err)
+		}
+		fixture.Body, err = os.ReadFile(strings.TrimSuffix(metaFile, fixtureMetaExt) + fixtureBodyExt)
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
+
+// RecordTransport 在转发请求的同时，把请求与响应录制到 Dir 中，供回放测试使用
+type RecordTransport struct {
+	Base http.RoundTripper
+	Dir  string
+}
+
+func NewRecordTransport(base http.RoundTripper, dir string) *RecordTransport {
+	return &RecordTransport{Base: base, Dir: dir}
+}
+
+func (rt *RecordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := rt.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// 响应体已被读取，需要放回去供调用方使用
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	fixture := &Fixture{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: res.StatusCode,
+		Header:     res.Header.Clone(),
+		Body:       body,
+	}
+	if err := SaveFixture(rt.Dir, fixture); err != nil {
+		return nil, fmt.Errorf("录制请求失败: %w", err)
+	}
+	return res, nil
+}
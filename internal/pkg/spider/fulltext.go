@@ -0,0 +1,321 @@
+package spider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+
+	"spider/db"
+)
+
+// DefaultFullTextInterval 两次全文下载之间的默认最小间隔，下载比详情页重得多，需要单独限速
+const DefaultFullTextInterval = time.Second * 10
+
+// 全文的格式
+const (
+	FullTextPDF = "pdf"
+	FullTextCAJ = "caj"
+)
+
+// ParseFullTextURL 解析详情页中的全文下载链接，优先 PDF，其次 CAJ
+// 链接可能是相对地址，需要根据详情页的地址补全
+func ParseFullTextURL(pageURL string, doc *html.Node) (fullTextURL, format string, err error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", "", err
+	}
+	for _, candidate := range []struct{ id, format string }{
+		{"pdfDown", FullTextPDF},
+		{"cajDown", FullTextCAJ},
+	} {
+		link, err := htmlquery.Query(doc, fmt.Sprintf("//a[@id='%s']", candidate.id))
+		if err != nil {
+			return "", "", err
+		}
+		if link == nil {
+			continue
+		}
+		href := strings.TrimSpace(htmlquery.SelectAttr(link, "href"))
+		if href == "" || strings.HasPrefix(href, "javascript:") {
+			continue
+		}
+		ref, err := url.Parse(href)
+		if err != nil {
+			return "", "", err
+		}
+		return base.ResolveReference(ref).String(), candidate.format, nil
+	}
+	return "", "", nil
+}
+
+// intervalLimiter 保证两次调用 Wait 之间至少间隔 interval
+type intervalLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+func (l *intervalLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	sleepWithContext(ctx, time.Until(start))
+	return ctx.Err()
+}
+
+// DefaultFullTextBuffer 全文下载队列的默认容量，队列满时跳过新的下载
+const DefaultFullTextBuffer = 1000
+
+// fullTextJob 是一个等待下载的全文，下载完成后填充路径、大小与校验和
+type fullTextJob struct {
+	PublicationNo string
+	URL           string
+	Format        string
+	Date          string
+	Code          string
+
+	Path   string
+	Size   int64
+	Sha256 string
+}
+
+// fullTextQueue 在单独的 goroutine 中按限速依次下载全文，下载完成后更新已保存专利的全文列
+// 专利本身不等待全文下载，worker 不受全文限速的影响
+type fullTextQueue struct {
+	s       *Spider
+	limiter *intervalLimiter
+	jobs    chan fullTextJob
+
+	mu     sync.RWMutex
+	closed bool
+	ctx    context.Context // 关闭超时后取消，中断进行中的下载
+	cancel context.CancelFunc
+	done   chan struct{} // 下载的 goroutine 退出时关闭
+}
+
+func newFullTextQueue(s *Spider, interval time.Duration, buffer int) *fullTextQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &fullTextQueue{
+		s:       s,
+		limiter: &intervalLimiter{interval: interval},
+		jobs:    make(chan fullTextJob, buffer),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go q.loop()
+	return q
+}
+
+// Add 把专利的全文加入下载队列，没有下载链接时什么也不做，队列满或已关闭时跳过
+func (q *fullTextQueue) Add(patent *Patent, date, code string) {
+	if patent.FullTextUrl == "" {
+		return
+	}
+	job := fullTextJob{
+		PublicationNo: patent.PublicationNo,
+		URL:           patent.FullTextUrl,
+		Format:        patent.FullTextFormat,
+		Date:          date,
+		Code:          code,
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		logrus.Warnf("全文下载队列已关闭，跳过，之后可用 fulltext backfill 补下载: %s", job.PublicationNo)
+		return
+	}
+	select {
+	case q.jobs <- job:
+	default:
+		logrus.Warnf("全文下载队列已满，跳过，之后可用 fulltext backfill 补下载: %s", job.PublicationNo)
+	}
+}
+
+func (q *fullTextQueue) loop() {
+	defer close(q.done)
+	for job := range q.jobs {
+		if err := q.limiter.Wait(q.ctx); err != nil {
+			return
+		}
+		q.s.fetchFullText(q.ctx, &job)
+	}
+}
+
+// fetchFullText 下载全文并更新专利的全文列，失败时只记录日志，专利的全文路径保持为空
+func (s *Spider) fetchFullText(ctx context.Context, job *fullTextJob) bool {
+	if err := s.downloadFullText(ctx, job); err != nil {
+		logrus.Warnf("下载全文失败: %s, %v", job.PublicationNo, err)
+		return false
+	}
+	if err := s.th.SaveFullText(job.PublicationNo, job.Path, job.Size, job.Sha256); err != nil {
+		logrus.Errorf("保存全文信息失败: %s, %v", job.PublicationNo, err)
+		return false
+	}
+	return true
+}
+
+// Close 不再接受新的下载，并等待队列中剩余的下载完成，ctx 结束时中断下载
+func (q *fullTextQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-q.done
+		return fmt.Errorf("等待全文下载超时，%d 个全文没有下载: %w", len(q.jobs), ctx.Err())
+	}
+}
+
+// findMissingFullText 查询 id 大于 afterID、有下载链接但还没有下载全文的专利，最多 limit 个
+// 日期与学科代码取自对应的任务（包括跳转的任务），用作全文的目录，找不到任务时为空
+func findMissingFullText(afterID uint, limit int) ([]uint, []fullTextJob, error) {
+	var rows []struct {
+		ID             uint
+		PublicationNo  string
+		FullTextUrl    string
+		FullTextFormat string
+		Date           string
+		Code           string
+	}
+	err := db.GetDB().Table("patents").
+		Select("patents.id, patents.publication_no, patents.full_text_url, patents.full_text_format, "+
+			"COALESCE(MAX(tasks.date), '') AS date, COALESCE(MAX(tasks.code), '') AS code").
+		Joins("LEFT JOIN tasks ON tasks.public_code = patents.publication_no OR tasks.redirect = patents.publication_no").
+		Where("patents.deleted_at IS NULL AND patents.id > ? AND patents.full_text_url <> '' AND patents.full_text_path = ''", afterID).
+		Group("patents.id").
+		Order("patents.id").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]uint, len(rows))
+	jobs := make([]fullTextJob, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+		jobs[i] = fullTextJob{
+			PublicationNo: row.PublicationNo,
+			URL:           row.FullTextUrl,
+			Format:        row.FullTextFormat,
+			Date:          row.Date,
+			Code:          row.Code,
+		}
+	}
+	return ids, jobs, nil
+}
+
+// BackfillFullText 按限速依次下载之前没有下载的全文，即有下载链接但全文路径为空的专利，
+// 如入队时队列已满或已关闭、退出时没有下载完、下载失败的全文，每批查询 batch 个，返回下载成功的数量
+func (s *Spider) BackfillFullText(ctx context.Context, interval time.Duration, batch int) (int, error) {
+	if interval <= 0 {
+		interval = DefaultFullTextInterval
+	}
+	limiter := &intervalLimiter{interval: interval}
+	var afterID uint
+	total := 0
+	for {
+		ids, jobs, err := findMissingFullText(afterID, batch)
+		if err != nil {
+			return total, err
+		}
+		if len(jobs) == 0 {
+			return total, nil
+		}
+		for i := range jobs {
+			if err := limiter.Wait(ctx); err != nil {
+				return total, err
+			}
+			if s.fetchFullText(ctx, &jobs[i]) {
+				total++
+			}
+		}
+		afterID = ids[len(ids)-1]
+	}
+}
+
+// SetFullText 开启全文下载，两次下载之间至少间隔 interval
+func (s *Spider) SetFullText(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultFullTextInterval
+	}
+	s.fullText = newFullTextQueue(s, interval, DefaultFullTextBuffer)
+}
+
+// downloadFullText 下载全文，保存到全文目录中，并在 job 中记录路径、大小与校验和
+func (s *Spider) downloadFullText(ctx context.Context, job *fullTextJob) error {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, job.URL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := (&http.Client{Transport: s.transport, Jar: jar}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("下载全文失败: %s, 状态码 %d", job.URL, res.StatusCode)
+	}
+	// 没有下载权限时返回的是登录页面
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		return fmt.Errorf("下载全文失败，没有下载权限: %s", job.URL)
+	}
+
+	dir := filepath.Join(FullTextDir, job.Date, job.Code)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, job.PublicationNo+"."+job.Format)
+	// 先写入临时文件，下载完整后再重命名，避免中断时留下不完整的文件
+	tmp, err := os.CreateTemp(dir, job.PublicationNo+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), res.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	job.Path, job.Size, job.Sha256 = path, size, hex.EncodeToString(hash.Sum(nil))
+	logrus.Infof("全文下载完成: %s, %d 字节", path, size)
+	return nil
+}
@@ -0,0 +1,153 @@
+package spider
+
+import (
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"spider/db"
+)
+
+// PatentChange 是重新爬取时专利一个列的变化，用于审计更新模式修改了什么
+type PatentChange struct {
+	gorm.Model
+
+	PatentPublicationNo string    `gorm:"index;size:32"`
+	TaskID              uint      `gorm:"index"`
+	Field               string    `gorm:"size:64"` // 列名，如 title
+	OldValue            string    `gorm:"type:text"`
+	NewValue            string    `gorm:"type:text"`
+	CrawledAt           time.Time // 本次爬取的时间
+	ParserVersion       string    `gorm:"size:64"` // 本次爬取的解析器版本
+}
+
+// 由这些列拆分得到的关联数据，列变化时整体替换
+var (
+	entityColumns = []string{"inventors", "applicant", "agent", "agency"}
+	ipcColumns    = []string{"classification_no", "main_classification_no"}
+	claimColumns  = []string{"sovereignty"}
+	dateColumns   = []string{"application_date", "publication_date", "auth_publication_date"}
+)
+
+// mergePatent 把本次爬取的非空值合并到已保存的专利上，并由合并后的值重新计算派生列
+// 省市区、公开号拆分、申请号与日期列都由其他列得到，只取本次爬取的值会与合并后的源列不一致
+func mergePatent(old, patent *Patent) *Patent {
+	merged := *old
+	mergedFields := merged.upsertFields()
+	for i, field := range patent.upsertFields() {
+		if *field.value != "" {
+			*mergedFields[i].value = *field.value
+		}
+	}
+	merged.PublicationCountry, merged.PublicationSerial, merged.PublicationKind, merged.KindCategory = "", "", "", ""
+	merged.ParseNumbers()
+	merged.NormalizeDates()
+	merged.ResolveRegion()
+	return &merged
+}
+
+// planUpsert 比较已保存的专利与本次爬取的专利，返回要更新的列与变化记录，派生列的变化同样记录
+// 本次为空的值不覆盖已有的值，避免一次不完整的页面抹掉之前的数据
+func planUpsert(taskID uint, old, patent *Patent, crawledAt time.Time) (map[string]interface{}, []PatentChange) {
+	merged := mergePatent(old, patent)
+	updates := make(map[string]interface{})
+	var changes []PatentChange
+	for _, diff := range diffPatents(old, merged) {
+		updates[diff.Column] = diff.New
+		changes = append(changes, PatentChange{
+			PatentPublicationNo: patent.PublicationNo,
+			TaskID:              taskID,
+			Field:               diff.Column,
+			OldValue:            diff.Old,
+			NewValue:            diff.New,
+			CrawledAt:           crawledAt,
+			ParserVersion:       patent.ParserVersion,
+		})
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if changedAny(updates, dateColumns) {
+		updates["application_day"] = merged.ApplicationDay
+		updates["publication_day"] = merged.PublicationDay
+		updates["auth_publication_day"] = merged.AuthPublicationDay
+	}
+	updates["parser_version"] = patent.ParserVersion
+	return updates, changes
+}
+
+func changedAny(updates map[string]interface{}, columns []string) bool {
+	for _, column := range columns {
+		if _, ok := updates[column]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// upsertPatent 用本次爬取的专利更新已保存的专利，并记录变化，返回变化的列数
+// 法律状态、引用关系等追加新出现的记录；发明人、IPC 分类号与权利要求由列拆分得到，列变化时整体替换
+func upsertPatent(tx *gorm.DB, taskID uint, old, patent *Patent, crawledAt time.Time) (int, error) {
+	updates, changes := planUpsert(taskID, old, patent, crawledAt)
+	if len(changes) > 0 {
+		if err := tx.Model(&Patent{}).Where("id = ?", old.ID).Updates(updates).Error; err != nil {
+			return 0, err
+		}
+		if err := tx.Create(&changes).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	// 替换的关联数据由合并后的列拆分，本次为空的列保留之前的拆分结果
+	no := patent.PublicationNo
+	merged := mergePatent(old, patent)
+	entities := patent
+	if changedAny(updates, entityColumns) {
+		if err := tx.Unscoped().Where("patent_publication_no = ?", no).Delete(&PatentEntity{}).Error; err != nil {
+			return 0, err
+		}
+		entities = merged
+	}
+	ipcs, claims := patent.IPCs, patent.Claims
+	if changedAny(updates, ipcColumns) {
+		if err := tx.Unscoped().Where("patent_publication_no = ?", no).Delete(&PatentIPC{}).Error; err != nil {
+			return 0, err
+		}
+		ipcs = ParseIPCList(no, merged.ClassificationNO, merged.MainClassificationNo)
+	}
+	if changedAny(updates, claimColumns) {
+		if err := tx.Unscoped().Where("patent_publication_no = ?", no).Delete(&Claim{}).Error; err != nil {
+			return 0, err
+		}
+		claims = ParseClaims(no, merged.Sovereignty)
+	}
+	if err := SaveEntities(tx, entities); err != nil {
+		return 0, err
+	}
+	nodes := IPCNodesOf(ipcs)
+	for _, association := range []interface{}{
+		&patent.Publications, &patent.LegalStatusEvents, &patent.Citations, &ipcs, &nodes, &claims, &patent.Aliases,
+	} {
+		if err := createIfAny(tx.Clauses(clause.OnConflict{DoNothing: true}), association); err != nil {
+			return 0, err
+		}
+	}
+	return len(changes), nil
+}
+
+// createIfAny 保存关联数据，records 为切片的指针，切片为空时什么也不做
+func createIfAny(tx *gorm.DB, records interface{}) error {
+	if reflect.ValueOf(records).Elem().Len() == 0 {
+		return nil
+	}
+	return tx.Create(records).Error
+}
+
+// FindPatentChanges 查询专利的变化记录，按时间排序
+func FindPatentChanges(publicationNo string) ([]PatentChange, error) {
+	var changes []PatentChange
+	err := db.GetDB().Where("patent_publication_no = ?", publicationNo).Order("id").Find(&changes).Error
+	return changes, err
+}
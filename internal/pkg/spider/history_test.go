@@ -0,0 +1,89 @@
+package spider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlanUpsert(t *testing.T) {
+	old := &Patent{
+		PublicationNo:   "CN113000001A",
+		Title:           "一种装置",
+		ApplicationDate: "2021-01-01",
+		Applicant:       "某某大学",
+		Abstract:        "旧的摘要",
+		ParserVersion:   "v1",
+	}
+	patent := &Patent{
+		PublicationNo:   "CN113000001A",
+		Title:           "一种装置",
+		ApplicationDate: "2021-01-02",
+		Applicant:       "",
+		Abstract:        "新的摘要",
+		ParserVersion:   "v2",
+	}
+	old.ParseNumbers()
+	patent.ParseNumbers()
+	patent.NormalizeDates()
+	crawledAt := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	updates, changes := planUpsert(7, old, patent, crawledAt)
+	// 标题没有变化，申请人本次为空，不覆盖
+	if len(changes) != 2 || changes[0].Field != "application_date" || changes[1].Field != "abstract" {
+		t.Fatalf("变化记录错误: %+v", changes)
+	}
+	change := changes[1]
+	if change.OldValue != "旧的摘要" || change.NewValue != "新的摘要" || change.TaskID != 7 ||
+		change.PatentPublicationNo != "CN113000001A" || change.ParserVersion != "v2" || !change.CrawledAt.Equal(crawledAt) {
+		t.Errorf("变化记录错误: %+v", change)
+	}
+	if _, ok := updates["applicant"]; ok {
+		t.Error("空值覆盖了已有的申请人")
+	}
+	if day, _ := updates["application_day"].(*time.Time); updates["parser_version"] != "v2" || day == nil || !day.Equal(*patent.ApplicationDay) {
+		t.Errorf("要更新的列错误: %v", updates)
+	}
+
+	if updates, changes := planUpsert(7, patent, patent, crawledAt); updates != nil || changes != nil {
+		t.Errorf("没有变化时不应当更新: %v, %v", updates, changes)
+	}
+}
+
+func TestPlanUpsertDerivedColumns(t *testing.T) {
+	old := &Patent{
+		PublicationNo:    "CN113000001A",
+		ApplicationNO:    "CN202110000001.X",
+		AreaCode:         "33",
+		ApplicantAddress: "310000 浙江省杭州市西湖区某某路1号",
+	}
+	old.ParseNumbers()
+	old.ResolveRegion()
+	// 本次地址为空，国省代码改为江苏，申请号修正
+	patent := &Patent{
+		PublicationNo: "CN113000001A",
+		ApplicationNO: "CN202110000002.1",
+		AreaCode:      "32",
+	}
+	patent.ParseNumbers()
+	patent.ResolveRegion()
+
+	updates, changes := planUpsert(7, old, patent, time.Now())
+	if updates["province"] != "江苏省" || updates["city"] != "" || updates["district"] != "" {
+		t.Errorf("省市区没有由合并后的国省代码与地址重新计算: %v", updates)
+	}
+	if updates["application_key"] != "CN202110000002" {
+		t.Errorf("application_key 没有更新: %v", updates["application_key"])
+	}
+	if _, ok := updates["applicant_address"]; ok {
+		t.Error("空值覆盖了已有的地址")
+	}
+	fields := make(map[string]bool)
+	for _, change := range changes {
+		fields[change.Field] = true
+	}
+	for _, field := range []string{"area_code", "application_no", "province", "city", "district", "application_key"} {
+		if !fields[field] {
+			t.Errorf("%s 的变化没有记录: %+v", field, changes)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package spider
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// html 的保存方式
+const (
+	HtmlStoreDir     = "dir"     // 每个页面一个文件，data/html/日期/学科代码/公开号.html
+	HtmlStoreArchive = "archive" // 压缩后写入滚动的归档分片，并维护索引
+	HtmlStoreWARC    = "warc"    // 所有请求与响应以 WARC 格式保存，包括状态码与头，见 WARCTransport
+)
+
+// DefaultShardSize 是归档分片的默认大小上限，超过后写入下一个分片
+const DefaultShardSize = 256 << 20
+
+// ErrHtmlNotFound 表示没有保存该公开号的页面
+var ErrHtmlNotFound = errors.New("没有保存该专利的页面")
+
+// ErrHtmlStoreClosed 表示归档已经关闭，退出时等待超时后仍在进行的保存会返回该错误
+var ErrHtmlStoreClosed = errors.New("html 归档已关闭")
+
+// HtmlStore 保存详情页的 html，并可以按公开号读取
+type HtmlStore interface {
+	Save(publicationNo, date, code string, body []byte) error
+	Load(publicationNo string) ([]byte, error) // 没有保存时返回 ErrHtmlNotFound
+	Close() error
+}
+
+// NewHtmlStore 按保存方式创建 HtmlStore，shardSize 为归档分片或 WARC 文件的大小上限
+func NewHtmlStore(kind, dir string, shardSize int64) (HtmlStore, error) {
+	switch kind {
+	case HtmlStoreDir:
+		return NewDirHtmlStore(dir), nil
+	case HtmlStoreArchive:
+		return OpenArchiveHtmlStore(dir, shardSize)
+	case HtmlStoreWARC:
+		writer, err := OpenWARCWriter(dir, shardSize)
+		if err != nil {
+			return nil, err
+		}
+		return NewWARCStore(writer), nil
+	}
+	return nil, fmt.Errorf("未知的 html 保存方式: %s，可选 warc、archive、dir", kind)
+}
+
+// unknownHtmlDir 是日期或学科代码为空时使用的目录名，如从引用关系中发现的任务
+const unknownHtmlDir = "unknown"
+
+// htmlEntryPath 返回页面在目录或归档中的相对路径：日期/学科代码/公开号.html
+// 日期与学科代码为空时用 unknown 代替，保持三级的布局
+func htmlEntryPath(publicationNo, date, code string) string {
+	if date == "" {
+		date = unknownHtmlDir
+	}
+	if code == "" {
+		code = unknownHtmlDir
+	}
+	return filepath.Join(date, code, publicationNo+".html")
+}
+
+// DirHtmlStore 是之前的保存方式，每个页面一个未压缩的文件
+type DirHtmlStore struct {
+	dir string
+}
+
+func NewDirHtmlStore(dir string) *DirHtmlStore {
+	return &DirHtmlStore{dir: dir}
+}
+
+func (d *DirHtmlStore) Save(publicationNo, date, code string, body []byte) error {
+	path := filepath.Join(d.dir, htmlEntryPath(publicationNo, date, code))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0o644)
+}
+
+// Load 在所有日期与学科代码的目录中查找页面
+// 之前日期与学科代码为空的页面直接保存在顶层目录中，同样查找
+func (d *DirHtmlStore) Load(publicationNo string) ([]byte, error) {
+	matches, err := filepath.Glob(filepath.Join(d.dir, "*", "*", publicationNo+".html"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		matches, err = filepath.Glob(filepath.Join(d.dir, publicationNo+".html"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(matches) == 0 {
+		return nil, ErrHtmlNotFound
+	}
+	return os.ReadFile(matches[0])
+}
+
+func (d *DirHtmlStore) Close() error {
+	return nil
+}
+
+// archiveEntry 是索引中的一条记录，指向分片中一个页面的位置
+type archiveEntry struct {
+	Shard  int
+	Offset int64
+	Length int64
+}
+
+// ArchiveHtmlStore 把页面写入 html-00001.tar.gz 这样的分片中，分片超过 shardSize 后写入下一个
+// 每个页面是一个单独的 gzip 成员，内容为一个 tar 条目（日期/学科代码/公开号.html），
+// 整个分片仍然是合法的 tar.gz，可以直接用 tar 解压；index.tsv 记录每个页面所在的分片、偏移与长度，用于按公开号读取
+type ArchiveHtmlStore struct {
+	dir       string
+	shardSize int64
+
+	mu     sync.Mutex
+	index  map[string]archiveEntry
+	shard  int      // 当前分片的编号
+	file   *os.File // 当前分片，还没有写入时为空
+	offset int64    // 当前分片已写入的大小
+	idx    *os.File // 索引文件
+	closed bool
+}
+
+// OpenArchiveHtmlStore 打开目录中的归档并读取索引，新的页面写入新的分片
+func OpenArchiveHtmlStore(dir string, shardSize int64) (*ArchiveHtmlStore, error) {
+	if shardSize <= 0 {
+		shardSize = DefaultShardSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	a := &ArchiveHtmlStore{dir: dir, shardSize: shardSize, index: make(map[string]archiveEntry)}
+	if err := a.loadIndex(); err != nil {
+		return nil, err
+	}
+	shards, err := filepath.Glob(filepath.Join(dir, "html-*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, shard := range shards {
+		if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(shard), "html-"), ".tar.gz")); err == nil && n > a.shard {
+			a.shard = n
+		}
+	}
+	a.idx, err = os.OpenFile(a.indexPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *ArchiveHtmlStore) indexPath() string {
+	return filepath.Join(a.dir, "index.tsv")
+}
+
+func (a *ArchiveHtmlStore) shardPath(shard int) string {
+	return filepath.Join(a.dir, fmt.Sprintf("html-%05d.tar.gz", shard))
+}
+
+// loadIndex 读取索引，同一个公开号以最后一条为准
+func (a *ArchiveHtmlStore) loadIndex() error {
+	f, err := os.Open(a.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 公开号、分片、偏移、长度、保存时间
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) < 4 {
+			continue
+		}
+		shard, err1 := strconv.Atoi(parts[1])
+		offset, err2 := strconv.ParseInt(parts[2], 10, 64)
+		length, err3 := strconv.ParseInt(parts[3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			// 写到一半时程序退出留下的不完整的行
+			continue
+		}
+		a.index[parts[0]] = archiveEntry{Shard: shard, Offset: offset, Length: length}
+	}
+	return scanner.Err()
+}
+
+func (a *ArchiveHtmlStore) Save(publicationNo, date, code string, body []byte) error {
+	member, err := gzipTarEntry(filepath.ToSlash(htmlEntryPath(publicationNo, date, code)), body)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// 关闭后不能再写入，否则会产生没有索引的新分片
+	if a.closed {
+		return ErrHtmlStoreClosed
+	}
+	if a.file != nil && a.offset+int64(len(member)) > a.shardSize && a.offset > 0 {
+		if err := a.closeShard(); err != nil {
+			return err
+		}
+	}
+	if a.file == nil {
+		a.shard++
+		if a.file, err = os.OpenFile(a.shardPath(a.shard), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644); err != nil {
+			return err
+		}
+		a.offset = 0
+	}
+	if _, err := a.file.Write(member); err != nil {
+		return err
+	}
+	entry := archiveEntry{Shard: a.shard, Offset: a.offset, Length: int64(len(member))}
+	a.offset += entry.Length
+	if _, err := fmt.Fprintf(a.idx, "%s\t%d\t%d\t%d\t%s\n", publicationNo, entry.Shard, entry.Offset, entry.Length,
+		time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	a.index[publicationNo] = entry
+	return nil
+}
+
+// gzipTarEntry 把页面编码为一个 tar 条目，并压缩为一个单独的 gzip 成员
+func gzipTarEntry(name string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), ModTime: time.Now()}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(body); err != nil {
+		return nil, err
+	}
+	// 只 Flush 而不 Close，tar 的结束标记在分片关闭时统一写入
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// closeShard 写入 tar 的结束标记并关闭当前分片
+func (a *ArchiveHtmlStore) closeShard() error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(make([]byte, 1024)); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	_, err := a.file.Write(buf.Bytes())
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	a.file = nil
+	return err
+}
+
+func (a *ArchiveHtmlStore) Load(publicationNo string) ([]byte, error) {
+	a.mu.Lock()
+	entry, ok := a.index[publicationNo]
+	a.mu.Unlock()
+	if !ok {
+		return nil, ErrHtmlNotFound
+	}
+	f, err := os.Open(a.shardPath(entry.Shard))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(io.NewSectionReader(f, entry.Offset, entry.Length))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(zr)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("读取归档 %s 失败: %w", a.shardPath(entry.Shard), err)
+	}
+	return io.ReadAll(tr)
+}
+
+func (a *ArchiveHtmlStore) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	var err error
+	if a.file != nil {
+		err = a.closeShard()
+	}
+	if closeErr := a.idx.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ImportHtmlDir 把目录布局中的页面导入 store，返回导入的页面数，用于把之前的 data/html 转为归档
+func ImportHtmlDir(dir string, store HtmlStore) (int, error) {
+	total := 0
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".html" {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		// 日期/学科代码/公开号.html，日期与学科代码为空的页面之前直接保存在顶层目录中
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		var publicationNo, date, code string
+		switch len(parts) {
+		case 3:
+			date, code, publicationNo = parts[0], parts[1], parts[2]
+		case 1:
+			publicationNo = parts[0]
+		default:
+			return nil
+		}
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := store.Save(strings.TrimSuffix(publicationNo, ".html"), date, code, body); err != nil {
+			return err
+		}
+		total++
+		return nil
+	})
+	return total, err
+}
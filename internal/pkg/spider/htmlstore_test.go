@@ -0,0 +1,147 @@
+package spider
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveHtmlStore(t *testing.T) {
+	dir := t.TempDir()
+	// 分片很小，每个分片只能放下几个页面
+	store, err := OpenArchiveHtmlStore(dir, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := make(map[string]string)
+	for i := 1; i <= 10; i++ {
+		no := fmt.Sprintf("CN%09dA", i)
+		pages[no] = fmt.Sprintf("<html><h1>专利 %d</h1>%s</html>", i, strings.Repeat("摘要", i*10))
+		if err := store.Save(no, "2021-06-08", "I138", []byte(pages[no])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重复保存时以最后一次为准
+	pages["CN000000001A"] = "<html>重新爬取</html>"
+	if err := store.Save("CN000000001A", "2021-06-08", "I138", []byte(pages["CN000000001A"])); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Load("CN000000003A"); err != nil || string(got) != pages["CN000000003A"] {
+		t.Errorf("关闭前读取错误: %q, %v", got, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 关闭后的保存返回错误，不会产生没有索引的新分片
+	if err := store.Save("CN000000011A", "2021-06-08", "I138", []byte("<html>迟到的页面</html>")); !errors.Is(err, ErrHtmlStoreClosed) {
+		t.Errorf("关闭后保存应当返回 ErrHtmlStoreClosed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("重复关闭: %v", err)
+	}
+
+	shards, _ := filepath.Glob(filepath.Join(dir, "html-*.tar.gz"))
+	if len(shards) < 2 {
+		t.Fatalf("没有滚动到新的分片: %v", shards)
+	}
+	// 每个分片都是合法的 tar.gz
+	entries := 0
+	for _, shard := range shards {
+		f, err := os.Open(shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(zr)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", shard, err)
+			}
+			if !strings.HasPrefix(header.Name, "2021-06-08/I138/CN") {
+				t.Errorf("条目名称错误: %s", header.Name)
+			}
+			entries++
+		}
+		f.Close()
+	}
+	if entries != 11 {
+		t.Errorf("分片中共有 %d 个条目, want 11", entries)
+	}
+
+	// 重新打开后从索引中读取，新的页面写入新的分片
+	store, err = OpenArchiveHtmlStore(dir, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for no, want := range pages {
+		if got, err := store.Load(no); err != nil || string(got) != want {
+			t.Errorf("%s: 读取错误: %q, %v", no, got, err)
+		}
+	}
+	if _, err := store.Load("CN999999999A"); !errors.Is(err, ErrHtmlNotFound) {
+		t.Errorf("没有保存的页面返回 %v", err)
+	}
+	if err := store.Save("CN000000011A", "2021-06-09", "I138", []byte("<html></html>")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("html-%05d.tar.gz", len(shards)+1))); err != nil {
+		t.Errorf("重新打开后没有写入新的分片: %v", err)
+	}
+}
+
+func TestImportHtmlDir(t *testing.T) {
+	src := NewDirHtmlStore(t.TempDir())
+	for _, no := range []string{"CN112926071A", "CN212341234U"} {
+		if err := src.Save(no, "2021-06-08", "I138", []byte("<html>"+no+"</html>")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := src.Load("CN212341234U"); err != nil || string(got) != "<html>CN212341234U</html>" {
+		t.Fatalf("目录布局读取错误: %q, %v", got, err)
+	}
+	// 从引用关系中发现的任务没有日期与学科代码
+	if err := src.Save("CN110598206A", "", "", []byte("<html>CN110598206A</html>")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(src.dir, "unknown", "unknown", "CN110598206A.html")); err != nil {
+		t.Errorf("日期与学科代码为空的页面没有保存到 unknown 目录: %v", err)
+	}
+	// 之前的版本直接保存在顶层目录中
+	if err := os.WriteFile(filepath.Join(src.dir, "CN109471938B.html"), []byte("<html>CN109471938B</html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, no := range []string{"CN110598206A", "CN109471938B"} {
+		if got, err := src.Load(no); err != nil || string(got) != "<html>"+no+"</html>" {
+			t.Errorf("%s 读取错误: %q, %v", no, got, err)
+		}
+	}
+
+	dst, err := OpenArchiveHtmlStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	total, err := ImportHtmlDir(src.dir, dst)
+	if err != nil || total != 4 {
+		t.Fatalf("导入了 %d 个页面: %v", total, err)
+	}
+	for _, no := range []string{"CN112926071A", "CN110598206A", "CN109471938B"} {
+		if got, err := dst.Load(no); err != nil || string(got) != "<html>"+no+"</html>" {
+			t.Errorf("%s 导入后读取错误: %q, %v", no, got, err)
+		}
+	}
+}
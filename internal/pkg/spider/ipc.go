@@ -0,0 +1,289 @@
+package spider
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"spider/db"
+)
+
+// IPC 的部与大类的名称，打包进二进制文件；小类及以下的名称可用 ImportIPCNodes 从 WIPO 等的分类表导入
+//
+//go:embed ipc/ipc.tsv
+var ipcTSV []byte
+
+// 形如 G06F16/35，可能带有空格与版本号，如 G06F 16/35(2019.01)，也可能只到小类，如 G06F
+var ipcReg = regexp.MustCompile(`^([A-H])(\d{2})([A-Z])(?:(\d{1,4})/(\d{1,6}))?`)
+
+// IPC 层级
+const (
+	IPCLevelSection   = "section"    // 部，如 G
+	IPCLevelClass     = "class"      // 大类，如 G06
+	IPCLevelSubclass  = "subclass"   // 小类，如 G06F
+	IPCLevelMainGroup = "main_group" // 大组，如 G06F16/00
+	IPCLevelSubgroup  = "subgroup"   // 小组，如 G06F16/35
+)
+
+// PatentIPC 是专利的一个 IPC 分类号，各层级的代码分别保存，方便按任意层级统计
+type PatentIPC struct {
+	gorm.Model
+
+	PatentPublicationNo string `gorm:"index:idx_patent_ipc,unique;size:32"`
+	Code                string `gorm:"index:idx_patent_ipc,unique;size:32"` // 完整的分类号，如 G06F16/35
+	Position            int    // 在分类号中的顺序，从 1 开始
+	Main                bool   // 是否为主分类号
+	Section             string `gorm:"index;size:1"`  // 部，如 G
+	Class               string `gorm:"index;size:3"`  // 大类，如 G06
+	Subclass            string `gorm:"index;size:4"`  // 小类，如 G06F
+	MainGroup           string `gorm:"index;size:16"` // 大组，如 G06F16/00，只到小类时为空
+	Subgroup            string `gorm:"index;size:32"` // 小组，如 G06F16/35，只到小类时为空
+}
+
+// IPCNode 是 IPC 层级中的一个节点，由打包的数据写入数据库，用于关联查询名称
+type IPCNode struct {
+	Code   string `gorm:"primaryKey;size:32"`
+	Parent string `gorm:"index;size:32"` // 上一级的代码，部为空
+	Level  string `gorm:"size:16"`
+	Title  string `gorm:"size:512"`
+}
+
+var ipcNodes = mustLoadIPCNodes()
+
+func mustLoadIPCNodes() map[string]IPCNode {
+	nodes, err := parseIPCNodes(bytes.NewReader(ipcTSV))
+	if err != nil {
+		panic(fmt.Sprintf("内置的 IPC 数据不合法: %v", err))
+	}
+	return nodes
+}
+
+// parseIPCNodes 解析“代码<TAB>名称”格式的 IPC 层级，# 开头的行为注释，层级与上一级由代码推出
+func parseIPCNodes(r io.Reader) (map[string]IPCNode, error) {
+	nodes := make(map[string]IPCNode)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("缺少名称: %q", line)
+		}
+		node, err := newIPCNode(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		node.Title = strings.TrimSpace(parts[1])
+		nodes[node.Code] = node
+	}
+	return nodes, scanner.Err()
+}
+
+// newIPCNode 根据代码推出节点的层级与上一级，代码必须是规范的写法，如 G、G06、G06F、G06F16/00、G06F16/35
+func newIPCNode(code string) (IPCNode, error) {
+	switch {
+	case len(code) == 1 && code >= "A" && code <= "H":
+		return IPCNode{Code: code, Level: IPCLevelSection}, nil
+	case len(code) == 3 && ipcReg.MatchString(code+"A"):
+		return IPCNode{Code: code, Parent: code[:1], Level: IPCLevelClass}, nil
+	}
+	ipc, err := ParseIPC(code)
+	if err != nil {
+		return IPCNode{}, err
+	}
+	switch code {
+	case ipc.Subclass:
+		return IPCNode{Code: code, Parent: ipc.Class, Level: IPCLevelSubclass}, nil
+	case ipc.MainGroup:
+		return IPCNode{Code: code, Parent: ipc.Subclass, Level: IPCLevelMainGroup}, nil
+	case ipc.Subgroup:
+		return IPCNode{Code: code, Parent: ipc.MainGroup, Level: IPCLevelSubgroup}, nil
+	}
+	return IPCNode{}, fmt.Errorf("不是规范的 IPC 分类号: %q", code)
+}
+
+// ParseIPC 解析一个分类号，返回的 PatentIPC 中只有分类号本身的字段
+func ParseIPC(code string) (PatentIPC, error) {
+	normalized := strings.ToUpper(removeAllBlank(code))
+	match := ipcReg.FindStringSubmatch(normalized)
+	if match == nil {
+		return PatentIPC{}, fmt.Errorf("无法解析 IPC 分类号: %q", code)
+	}
+	ipc := PatentIPC{
+		Section:  match[1],
+		Class:    match[1] + match[2],
+		Subclass: match[1] + match[2] + match[3],
+	}
+	ipc.Code = ipc.Subclass
+	if match[4] != "" {
+		ipc.MainGroup = ipc.Subclass + match[4] + "/00"
+		ipc.Subgroup = ipc.Subclass + match[4] + "/" + match[5]
+		ipc.Code = ipc.Subgroup
+	}
+	return ipc, nil
+}
+
+// ParseIPCList 解析分类号与主分类号，无法解析的分类号被忽略
+// 主分类号不在分类号中时放在第一个
+func ParseIPCList(publicationNo, classificationNo, mainClassificationNo string) []PatentIPC {
+	var ipcs []PatentIPC
+	seen := make(map[string]int)
+	add := func(raw string, main bool) {
+		ipc, err := ParseIPC(raw)
+		if err != nil {
+			return
+		}
+		if i, ok := seen[ipc.Code]; ok {
+			ipcs[i].Main = ipcs[i].Main || main
+			return
+		}
+		ipc.PatentPublicationNo = publicationNo
+		ipc.Main = main
+		seen[ipc.Code] = len(ipcs)
+		ipcs = append(ipcs, ipc)
+	}
+	add(mainClassificationNo, true)
+	for _, raw := range strings.FieldsFunc(classificationNo, func(r rune) bool {
+		return r == ';' || r == '；' || r == ',' || r == '，'
+	}) {
+		add(raw, false)
+	}
+	for i := range ipcs {
+		ipcs[i].Position = i + 1
+	}
+	return ipcs
+}
+
+// IPCHierarchy 返回分类号从部到小组的各级节点，没有收录名称的节点 Title 为空
+func IPCHierarchy(code string) ([]IPCNode, error) {
+	ipc, err := ParseIPC(code)
+	if err != nil {
+		return nil, err
+	}
+	levels := []struct{ code, level string }{
+		{ipc.Section, IPCLevelSection},
+		{ipc.Class, IPCLevelClass},
+		{ipc.Subclass, IPCLevelSubclass},
+		{ipc.MainGroup, IPCLevelMainGroup},
+		{ipc.Subgroup, IPCLevelSubgroup},
+	}
+	var nodes []IPCNode
+	parent := ""
+	for _, level := range levels {
+		// 大组本身就是 /00 时，小组与大组相同
+		if level.code == "" || level.code == parent {
+			break
+		}
+		node := IPCNode{Code: level.code, Parent: parent, Level: level.level, Title: ipcNodes[level.code].Title}
+		nodes = append(nodes, node)
+		parent = level.code
+	}
+	return nodes, nil
+}
+
+// SeedIPCNodes 把打包的 IPC 层级写入数据库，已存在的节点会被更新
+func SeedIPCNodes(tx *gorm.DB) error {
+	nodes := make([]IPCNode, 0, len(ipcNodes))
+	for _, node := range ipcNodes {
+		nodes = append(nodes, node)
+	}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&nodes).Error
+}
+
+// IPCNodesOf 返回分类号各级的节点，包括小类、大组与小组，这样按任意层级关联 ipc_nodes 都有对应的行
+// 没有收录名称的节点 Title 为空，保存时不覆盖已导入的名称
+func IPCNodesOf(ipcs []PatentIPC) []IPCNode {
+	var nodes []IPCNode
+	seen := make(map[string]bool)
+	for _, ipc := range ipcs {
+		hierarchy, err := IPCHierarchy(ipc.Code)
+		if err != nil {
+			continue
+		}
+		for _, node := range hierarchy {
+			if !seen[node.Code] {
+				seen[node.Code] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes
+}
+
+// ImportIPCNodes 从“代码<TAB>名称”格式的分类表导入 IPC 节点的名称，已存在的节点会被更新，返回导入的节点数
+func ImportIPCNodes(tx *gorm.DB, r io.Reader) (int, error) {
+	parsed, err := parseIPCNodes(r)
+	if err != nil {
+		return 0, err
+	}
+	nodes := make([]IPCNode, 0, len(parsed))
+	for _, node := range parsed {
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return 0, nil
+	}
+	if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&nodes, 1000).Error; err != nil {
+		return 0, err
+	}
+	return len(nodes), nil
+}
+
+// FillIPCTitles 用数据库中导入的名称补全没有收录名称的节点
+func FillIPCTitles(nodes []IPCNode) error {
+	codes := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Title == "" {
+			codes = append(codes, node.Code)
+		}
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	var saved []IPCNode
+	if err := db.GetDB().Where("code IN ?", codes).Find(&saved).Error; err != nil {
+		return err
+	}
+	titles := make(map[string]string, len(saved))
+	for _, node := range saved {
+		titles[node.Code] = node.Title
+	}
+	for i := range nodes {
+		if nodes[i].Title == "" {
+			nodes[i].Title = titles[nodes[i].Code]
+		}
+	}
+	return nil
+}
+
+// BackfillIPCs 为已保存的专利拆分分类号，每批处理 batch 个专利，返回处理的专利数
+func BackfillIPCs(batch int) (int, error) {
+	var patents []Patent
+	total := 0
+	err := db.GetDB().
+		Select("id", "publication_no", "classification_no", "main_classification_no").
+		FindInBatches(&patents, batch, func(tx *gorm.DB, _ int) error {
+			var ipcs []PatentIPC
+			for _, patent := range patents {
+				ipcs = append(ipcs, ParseIPCList(patent.PublicationNo, patent.ClassificationNO, patent.MainClassificationNo)...)
+			}
+			nodes := IPCNodesOf(ipcs)
+			for _, records := range []interface{}{&ipcs, &nodes} {
+				if err := createIfAny(db.GetDB().Clauses(clause.OnConflict{DoNothing: true}), records); err != nil {
+					return err
+				}
+			}
+			total += len(patents)
+			return nil
+		}).Error
+	return total, err
+}
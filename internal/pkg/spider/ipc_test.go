@@ -0,0 +1,86 @@
+package spider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIPC(t *testing.T) {
+	ipc, err := ParseIPC("g06f 16/35(2019.01)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PatentIPC{Code: "G06F16/35", Section: "G", Class: "G06", Subclass: "G06F", MainGroup: "G06F16/00", Subgroup: "G06F16/35"}
+	if ipc != want {
+		t.Errorf("ParseIPC = %+v, want %+v", ipc, want)
+	}
+	if ipc, err := ParseIPC("H04L"); err != nil || ipc.Code != "H04L" || ipc.MainGroup != "" {
+		t.Errorf("只到小类的分类号解析错误: %+v, %v", ipc, err)
+	}
+	if _, err := ParseIPC("暂无"); err == nil {
+		t.Error("不合法的分类号应当返回错误")
+	}
+}
+
+func TestParseIPCList(t *testing.T) {
+	ipcs := ParseIPCList("CN112926071A", "G06F16/35;G06N3/04；G06N3/08;无", "G06N3/08")
+	want := []struct {
+		code string
+		main bool
+	}{{"G06N3/08", true}, {"G06F16/35", false}, {"G06N3/04", false}}
+	if len(ipcs) != len(want) {
+		t.Fatalf("拆分出 %d 个分类号: %+v", len(ipcs), ipcs)
+	}
+	for i, w := range want {
+		if ipcs[i].Code != w.code || ipcs[i].Main != w.main || ipcs[i].Position != i+1 || ipcs[i].PatentPublicationNo != "CN112926071A" {
+			t.Errorf("第 %d 个分类号为 %+v", i, ipcs[i])
+		}
+	}
+}
+
+func TestIPCHierarchy(t *testing.T) {
+	nodes, err := IPCHierarchy("G06F16/35")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := []string{"G", "G06", "G06F", "G06F16/00", "G06F16/35"}
+	if len(nodes) != len(codes) {
+		t.Fatalf("层级为 %+v", nodes)
+	}
+	for i, code := range codes {
+		if nodes[i].Code != code || (i > 0 && nodes[i].Parent != codes[i-1]) {
+			t.Errorf("第 %d 级为 %+v", i, nodes[i])
+		}
+	}
+	if nodes[0].Title != "物理" || nodes[1].Title != "计算；推算或计数" {
+		t.Errorf("部与大类的名称错误: %q, %q", nodes[0].Title, nodes[1].Title)
+	}
+}
+
+func TestIPCNodesOf(t *testing.T) {
+	nodes := IPCNodesOf(ParseIPCList("CN112926071A", "G06F16/35;G06N3/04", "G06F16/35"))
+	codes := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		codes = append(codes, node.Code)
+	}
+	want := "G,G06,G06F,G06F16/00,G06F16/35,G06N,G06N3/00,G06N3/04"
+	if got := strings.Join(codes, ","); got != want {
+		t.Errorf("节点为 %s, want %s", got, want)
+	}
+}
+
+func TestParseIPCNodes(t *testing.T) {
+	nodes, err := parseIPCNodes(strings.NewReader("# 小类与大组\nG06F\t电数字数据处理\nG06F16/00\t信息检索；数据库结构\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node := nodes["G06F"]; node.Level != IPCLevelSubclass || node.Parent != "G06" || node.Title != "电数字数据处理" {
+		t.Errorf("小类解析错误: %+v", node)
+	}
+	if node := nodes["G06F16/00"]; node.Level != IPCLevelMainGroup || node.Parent != "G06F" {
+		t.Errorf("大组解析错误: %+v", node)
+	}
+	if _, err := parseIPCNodes(strings.NewReader("G06F 16/35\t不规范的代码\n")); err == nil {
+		t.Error("不规范的代码应当返回错误")
+	}
+}
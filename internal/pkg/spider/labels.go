@@ -0,0 +1,150 @@
+package spider
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// DefaultLabelsFlushInterval 未识别标签的统计多久写入一次数据库
+const DefaultLabelsFlushInterval = time.Minute
+
+// UnknownLabel 是字段映射规则中没有的标签，所有爬虫的统计汇总在一张表中
+type UnknownLabel struct {
+	gorm.Model
+
+	Label         string    `gorm:"index:idx_unknown_label,unique;size:128"`
+	Occurrences   int64     // 出现的次数
+	ExampleValue  string    // 最近一次出现时的值
+	ExamplePatent string    `gorm:"size:32"` // 最近一次出现时所在专利的公开号
+	LastSeenAt    time.Time // 最近一次出现的时间
+}
+
+// RawField 是详情页中的一对标签与值
+type RawField struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// encodeRawFields 把原始的标签与值编码为 JSON，用于 Patent.RawFields
+func encodeRawFields(fields []RawField) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	content, err := json.Marshal(fields)
+	if err != nil {
+		// []RawField 只包含字符串，不会失败
+		panic(err)
+	}
+	return string(content)
+}
+
+// DecodeRawFields 解析 Patent.RawFields
+func DecodeRawFields(rawFields string) ([]RawField, error) {
+	if rawFields == "" {
+		return nil, nil
+	}
+	var fields []RawField
+	if err := json.Unmarshal([]byte(rawFields), &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// labelCounter 统计本进程中出现的未识别标签，定期写入数据库
+type labelCounter struct {
+	mu      sync.Mutex
+	seen    map[string]bool          // 本进程中出现过的标签，只在第一次出现时打印日志
+	pending map[string]*UnknownLabel // 还未写入数据库的增量
+}
+
+func newLabelCounter() *labelCounter {
+	return &labelCounter{
+		seen:    make(map[string]bool),
+		pending: make(map[string]*UnknownLabel),
+	}
+}
+
+// Add 记录专利中的未识别标签
+func (c *labelCounter) Add(patent *Patent) {
+	if len(patent.UnknownLabels) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, field := range patent.UnknownLabels {
+		if !c.seen[field.Label] {
+			c.seen[field.Label] = true
+			logrus.Warnf("发现字段映射规则中没有的标签: %q，值: %q，专利: %s", field.Label, field.Value, patent.PublicationNo)
+		}
+		label, ok := c.pending[field.Label]
+		if !ok {
+			label = &UnknownLabel{Label: field.Label}
+			c.pending[field.Label] = label
+		}
+		label.Occurrences++
+		label.ExampleValue = truncateRunes(field.Value, 255)
+		label.ExamplePatent = patent.PublicationNo
+		label.LastSeenAt = now
+	}
+}
+
+// Flush 把增量写入数据库，失败时保留增量等待下次写入
+func (c *labelCounter) Flush(th TaskHandler) error {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	labels := make([]UnknownLabel, 0, len(c.pending))
+	for _, label := range c.pending {
+		labels = append(labels, *label)
+	}
+	c.pending = make(map[string]*UnknownLabel)
+	c.mu.Unlock()
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Label < labels[j].Label })
+	if err := th.SaveUnknownLabels(labels); err != nil {
+		c.restore(labels)
+		return err
+	}
+	for _, label := range labels {
+		logrus.Infof("未识别的标签 %q 新出现 %d 次", label.Label, label.Occurrences)
+	}
+	return nil
+}
+
+// restore 把写入失败的增量合并回去
+func (c *labelCounter) restore(labels []UnknownLabel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range labels {
+		if label, ok := c.pending[labels[i].Label]; ok {
+			label.Occurrences += labels[i].Occurrences
+			continue
+		}
+		c.pending[labels[i].Label] = &labels[i]
+	}
+}
+
+// flushLoop 每隔 interval 写入一次，ctx 结束时返回
+func (c *labelCounter) flushLoop(ctx context.Context, th TaskHandler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Flush(th); err != nil {
+				logrus.Errorf("保存未识别标签的统计失败: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
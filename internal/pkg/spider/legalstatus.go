@@ -0,0 +1,99 @@
+package spider
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"gorm.io/gorm"
+)
+
+// 由法律状态事件推断出的当前状态
+const (
+	LegalStatusPending = "审中"
+	LegalStatusValid   = "有效"
+	LegalStatusInvalid = "失效"
+)
+
+// 法律状态中表示专利失效的关键字，如"未缴年费专利权终止"、"发明专利申请公布后的驳回"
+var legalStatusInvalidKeywords = []string{"终止", "驳回", "撤回", "放弃", "无效"}
+
+// LegalStatusEvent 是专利的一条法律状态事件，如授权、未缴年费终止、权利转移
+type LegalStatusEvent struct {
+	gorm.Model
+
+	PatentPublicationNo string `gorm:"index:idx_patent_legal_status,unique;size:32"` // 所属专利的公开号
+	EffectiveDate       string `gorm:"index:idx_patent_legal_status,unique;size:16"` // 法律状态公告日
+	StatusCode          string `gorm:"index:idx_patent_legal_status,unique;size:64"` // 法律状态
+	Description         string // 法律状态信息
+}
+
+// ParseLegalStatus 解析法律状态的 html 片段，每行依次是公告日、法律状态、法律状态信息
+// 返回的事件按公告日从早到晚排序
+func ParseLegalStatus(patentPublicationNo, body string) ([]LegalStatusEvent, error) {
+	doc, err := htmlquery.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := htmlquery.QueryAll(doc, "//tr")
+	if err != nil {
+		return nil, err
+	}
+
+	var events []LegalStatusEvent
+	for _, row := range rows {
+		cells, err := htmlquery.QueryAll(row, "./td")
+		if err != nil {
+			return nil, err
+		}
+		// 表头或不完整的行
+		if len(cells) < 2 {
+			continue
+		}
+		date := normalizePublicationDate(publicationDateReg.FindString(htmlquery.InnerText(cells[0])))
+		statusCode := strings.TrimSpace(htmlquery.InnerText(cells[1]))
+		if date == "" || statusCode == "" {
+			continue
+		}
+		event := LegalStatusEvent{
+			PatentPublicationNo: patentPublicationNo,
+			EffectiveDate:       date,
+			StatusCode:          statusCode,
+		}
+		if len(cells) > 2 {
+			event.Description = joinedText(cells[2])
+		}
+		events = append(events, event)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].EffectiveDate < events[j].EffectiveDate
+	})
+	return events, nil
+}
+
+// CurrentLegalStatus 根据按时间排序的法律状态事件推断专利的当前状态
+// 转移、变更等事件不影响专利是否有效，沿用之前的状态
+func CurrentLegalStatus(events []LegalStatusEvent) string {
+	if len(events) == 0 {
+		return ""
+	}
+	status := LegalStatusPending
+	for _, event := range events {
+		switch {
+		case containsAny(event.StatusCode, legalStatusInvalidKeywords):
+			status = LegalStatusInvalid
+		case strings.Contains(event.StatusCode, "授权"), strings.Contains(event.StatusCode, "恢复"):
+			status = LegalStatusValid
+		}
+	}
+	return status
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package spider
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// TextRendering 决定摘要、主权项等带有行内标记的字段如何转为文本
+type TextRendering string
+
+const (
+	RenderPlain  TextRendering = "plain"  // 纯文本，上下标与斜体只保留文字
+	RenderMarkup TextRendering = "markup" // 保留 <sub>、<sup>、<i> 标记，如 H<sub>2</sub>O，文本中的 <、> 与 & 转义
+)
+
+// ParseTextRendering 解析命令行中的渲染方式
+func ParseTextRendering(rendering string) (TextRendering, error) {
+	switch r := TextRendering(rendering); r {
+	case RenderPlain, RenderMarkup:
+		return r, nil
+	}
+	return "", fmt.Errorf("未知的文本渲染方式: %s，可选 plain、markup", rendering)
+}
+
+// 保留的行内标记，统一为小写，<em> 当作 <i>
+var inlineMarkup = map[string]string{
+	"sub": "sub",
+	"sup": "sup",
+	"i":   "i",
+	"em":  "i",
+}
+
+var htmlWhitespace = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "\t", " ")
+
+// 前后换行的块级元素
+var blockElements = map[string]bool{
+	"p": true, "div": true, "li": true, "tr": true,
+}
+
+// RenderText 提取节点下的全部文本，<br> 与块级元素转为换行
+// 之前只取第一个文本节点，遇到化学式、单位中的上下标时摘要会被截断
+func RenderText(node *html.Node, rendering TextRendering) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			// 源码中的换行只是排版，与空格相同
+			text := htmlWhitespace.Replace(n.Data)
+			// 保留标记时结果是 html 片段，文本中的 < 等需要转义，否则与标记无法区分
+			if rendering == RenderMarkup {
+				text = html.EscapeString(text)
+			}
+			b.WriteString(text)
+			return
+		case html.ElementNode:
+			tag := strings.ToLower(n.Data)
+			if tag == "br" {
+				b.WriteByte('\n')
+				return
+			}
+			if tag == "script" || tag == "style" {
+				return
+			}
+			if blockElements[tag] {
+				b.WriteByte('\n')
+				defer b.WriteByte('\n')
+			}
+			if markup, ok := inlineMarkup[tag]; ok && rendering == RenderMarkup {
+				b.WriteString("<" + markup + ">")
+				defer b.WriteString("</" + markup + ">")
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return b.String()
+}
+
+// NormalizeRich 规范化带有换行的文本，每一行同 NormalizeProse，去掉空行
+func NormalizeRich(str string) string {
+	lines := strings.Split(str, "\n")
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = NormalizeProse(line); line != "" {
+			result = append(result, line)
+		}
+	}
+	return strings.Join(result, "\n")
+}
+
+// stripMarkup 去掉 RenderMarkup 保留的标记，并还原转义的文本
+func stripMarkup(str string) string {
+	for _, markup := range []string{"sub", "sup", "i"} {
+		str = strings.ReplaceAll(str, "<"+markup+">", "")
+		str = strings.ReplaceAll(str, "</"+markup+">", "")
+	}
+	return html.UnescapeString(str)
+}
@@ -0,0 +1,38 @@
+package spider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antchfx/htmlquery"
+)
+
+func TestRenderText(t *testing.T) {
+	doc, err := htmlquery.Parse(strings.NewReader(`<div class="abstract-text">本发明制备的H<sub>2</sub>O<SUP>+</SUP>浓度为10<sup>-3</sup> mol/L，
+培养<i>E. coli</i>  菌<br/>第二段<p>第三段</p>x&lt;5&lt;i&gt;中</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := htmlquery.FindOne(doc, "//div[@class='abstract-text']")
+	cases := map[TextRendering]string{
+		RenderPlain:  "本发明制备的H2O+浓度为10-3 mol/L，培养E. coli菌\n第二段\n第三段\nx<5<i>中",
+		RenderMarkup: "本发明制备的H<sub>2</sub>O<sup>+</sup>浓度为10<sup>-3</sup> mol/L，培养<i>E. coli</i>菌\n第二段\n第三段\nx&lt;5&lt;i&gt;中",
+	}
+	for rendering, want := range cases {
+		if got := NormalizeRich(RenderText(node, rendering)); got != want {
+			t.Errorf("%s:\n got %q\nwant %q", rendering, got, want)
+		}
+	}
+	if got := stripMarkup(cases[RenderMarkup]); got != cases[RenderPlain] {
+		t.Errorf("stripMarkup = %q", got)
+	}
+}
+
+func TestParseTextRendering(t *testing.T) {
+	if r, err := ParseTextRendering("markup"); err != nil || r != RenderMarkup {
+		t.Errorf("ParseTextRendering(markup) = %q, %v", r, err)
+	}
+	if _, err := ParseTextRendering("html"); err == nil {
+		t.Error("未知的渲染方式应当返回错误")
+	}
+}
@@ -2,6 +2,7 @@ package spider
 
 import (
 	"regexp"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -11,8 +12,11 @@ type Patent struct {
 
 	Title                string // 标题
 	Url                  string // 专利的url
+	Source               string `gorm:"size:32;default:cnki"` // 专利来源
+	DBCode               string `gorm:"size:16;default:SCPD"` // 知网专利数据库代码
 	NaviCode             string // 学科代码
-	Year                 string // 年份, 应该是公开日的年份，仅作爬虫分类用，不一定准确
+	Year                 string // 年份，公开公告日或授权公告日的年份，都没有时取任务中的日期
+	ParserVersion        string // 解析时所用字段映射规则的版本
 	ApplicationType      string // 专利类型
 	ApplicationDate      string // 申请日
 	PublicationNo        string `gorm:"index:idx_public_no,unique"` // 申请公布号/授权公布号, 用于去重
@@ -33,110 +37,39 @@ type Patent struct {
 	Page                 string // 页数
 	Abstract             string // 摘要
 	Sovereignty          string // 主权项
-	LegalStatus          string // 法律状态
-}
+	LegalStatus          string // 当前法律状态，由法律状态事件推断：审中、有效、失效
+	FullTextUrl          string // 全文下载链接
+	FullTextFormat       string // 全文格式：pdf、caj
+	FullTextPath         string // 全文的本地保存路径，未下载时为空
+	FullTextSize         int64  // 全文大小，单位字节
+	FullTextSha256       string // 全文的 sha256 校验和
+	RawFields            string `gorm:"type:text"` // 详情页中所有标签与值的原始 JSON，包括规则中没有的标签，见 DecodeRawFields
 
-// FillRowFields 填充专利的字段
-func (patent *Patent) FillRowFields(key, value string) {
-	switch key {
-	case "专利类型：":
-		patent.ApplicationType = value
-	case "申请日：":
-		patent.ApplicationDate = value
-	// 多次公布后面发现是动态加载，其实这样获取不到，但是，不同阶段的专利的申请（专利）号是一样的，多次公布不爬问题也不大
-	case "多次公布：":
-		patent.MultiPublicationNo = value
-	case "申请人：":
-		patent.Applicant = value
-	case "地址：":
-		patent.ApplicantAddress = value
-	case "发明人：":
-		patent.Inventors = value
-	case "申请(专利)号：", "申请（专利）号：":
-		patent.ApplicationNO = value
-	case "申请公布号：":
-		patent.ApplyPublicationNo = value
-	case "授权公布号：":
-		patent.AuthPublicationNo = value
-	case "公开公告日：":
-		patent.PublicationDate = value
-	case "授权公告日：":
-		patent.AuthPublicationDate = value
-	case "国省代码：":
-		patent.AreaCode = value
-	case "分类号：":
-		patent.ClassificationNO = value
-	case "主分类号：":
-		patent.MainClassificationNo = value
-	case "代理机构：":
-		patent.Agency = value
-	case "代理人：":
-		patent.Agent = value
-	case "页数：":
-		patent.Page = value
-	}
-}
+	// 由上面的日期字符串解析得到，无法解析时为空，见 NormalizeDates
+	ApplicationDay     *time.Time `gorm:"type:date;index"` // 申请日
+	PublicationDay     *time.Time `gorm:"type:date;index"` // 公开公告日
+	AuthPublicationDay *time.Time `gorm:"type:date;index"` // 授权公告日
 
-// RemoveAllBlank 去除专利所有空白字符
-func (patent *Patent) RemoveAllBlank() {
-	// 对每个字段都调用 removeAllBlank 函数
-	patent.Title = removeAllBlank(patent.Title)
-	patent.Url = removeAllBlank(patent.Url)
-	patent.NaviCode = removeAllBlank(patent.NaviCode)
-	patent.Year = removeAllBlank(patent.Year)
-	patent.ApplicationType = removeAllBlank(patent.ApplicationType)
-	patent.ApplicationDate = removeAllBlank(patent.ApplicationDate)
-	patent.PublicationNo = removeAllBlank(patent.PublicationNo)
-	patent.ApplyPublicationNo = removeAllBlank(patent.ApplyPublicationNo)
-	patent.AuthPublicationNo = removeAllBlank(patent.AuthPublicationNo)
-	patent.MultiPublicationNo = removeAllBlank(patent.MultiPublicationNo)
-	patent.PublicationDate = removeAllBlank(patent.PublicationDate)
-	patent.AuthPublicationDate = removeAllBlank(patent.AuthPublicationDate)
-	patent.Applicant = removeAllBlank(patent.Applicant)
-	patent.ApplicantAddress = removeAllBlank(patent.ApplicantAddress)
-	patent.Inventors = removeAllBlank(patent.Inventors)
-	patent.ApplicationNO = removeAllBlank(patent.ApplicationNO)
-	patent.AreaCode = removeAllBlank(patent.AreaCode)
-	patent.ClassificationNO = removeAllBlank(patent.ClassificationNO)
-	patent.MainClassificationNo = removeAllBlank(patent.MainClassificationNo)
-	patent.Agency = removeAllBlank(patent.Agency)
-	patent.Agent = removeAllBlank(patent.Agent)
-	patent.Page = removeAllBlank(patent.Page)
-	patent.Abstract = removeAllBlank(patent.Abstract)
-	patent.Sovereignty = removeAllBlank(patent.Sovereignty)
-	patent.LegalStatus = removeAllBlank(patent.LegalStatus)
-}
+	// 由国省代码与地址解析得到，无法解析时为空，见 ResolveRegion
+	Province string `gorm:"index;size:32"` // 省级行政区，如江苏省
+	City     string `gorm:"index;size:32"` // 地级行政区，直辖市为其本身，如苏州市
+	District string `gorm:"size:32"`       // 区县，如西湖区
+
+	// 由公开号与申请号解析得到，见 ParseNumbers
+	PublicationCountry string `gorm:"index;size:2"`  // 公开号的国家代码，如 CN
+	PublicationSerial  string `gorm:"size:16"`       // 公开号的序号，如 112926071
+	PublicationKind    string `gorm:"size:4"`        // 公开号的类型码，如 A、B2
+	KindCategory       string `gorm:"index;size:1"`  // 文献类型：A 申请公布，B 发明授权，U 实用新型，S 外观设计
+	ApplicationKey     string `gorm:"index;size:32"` // 规范化的申请号，同一申请的各阶段文献相同，用于统计发明数
+
+	Publications      []PublicationRecord `gorm:"foreignKey:PatentPublicationNo;references:PublicationNo"` // 多次公布的各个阶段
+	LegalStatusEvents []LegalStatusEvent  `gorm:"foreignKey:PatentPublicationNo;references:PublicationNo"` // 法律状态事件，按公告日排序
+	Citations         []Citation          `gorm:"foreignKey:SourcePublicationNo;references:PublicationNo"` // 引证文献与被引文献
+	IPCs              []PatentIPC         `gorm:"foreignKey:PatentPublicationNo;references:PublicationNo"` // 拆分后的 IPC 分类号
+	Aliases           []PublicationAlias  `gorm:"foreignKey:PublicationNo;references:PublicationNo"`       // 跳转到本专利的任务中的公开号
+	Claims            []Claim             `gorm:"foreignKey:PatentPublicationNo;references:PublicationNo"` // 拆分后的权利要求
 
-// Validate 校验专利的字段，如果空值太多，则失败
-func (patent *Patent) Validate() bool {
-	if patent.Title == "" {
-		return false
-	}
-	// 至少应用 8 个字段不为空
-	notEmptyCount := 0
-	for _, field := range []string{
-		patent.ApplicationType,
-		patent.ApplicationDate,
-		patent.ApplyPublicationNo,
-		patent.AuthPublicationNo,
-		patent.PublicationDate,
-		patent.AuthPublicationDate,
-		patent.Applicant,
-		patent.ApplicantAddress,
-		patent.Inventors,
-		patent.ApplicationNO,
-		patent.AreaCode,
-		patent.ClassificationNO,
-		patent.MainClassificationNo,
-		patent.Agency,
-		patent.Agent,
-		patent.Page,
-	} {
-		if field != "" {
-			notEmptyCount++
-		}
-	}
-	return notEmptyCount >= 8
+	UnknownLabels []RawField `gorm:"-"` // 字段映射规则中没有的标签，只用于统计，不单独保存
 }
 
 func removeAllBlank(str string) string {
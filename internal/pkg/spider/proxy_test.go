@@ -9,6 +9,9 @@ import (
 )
 
 func TestProxyGoRequest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("需要访问真实的代理，short 模式下跳过")
+	}
 	for i := 0; i < 20; i++ {
 		doRequest()
 		time.Sleep(50 * time.Millisecond)
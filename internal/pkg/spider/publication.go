@@ -0,0 +1,101 @@
+package spider
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+)
+
+// 公布阶段
+const (
+	StageApplyPublication = "申请公布"
+	StageAuthPublication  = "授权公告"
+	StageCorrection       = "更正"
+)
+
+var publicationDateReg = regexp.MustCompile(`\d{4}[-./年]\d{1,2}[-./月]\d{1,2}`)
+
+// PublicationRecord 是专利的一次公布，同一申请的申请公布、授权公告与更正各是一条
+type PublicationRecord struct {
+	gorm.Model
+
+	PatentPublicationNo string `gorm:"index:idx_patent_publication,unique;size:32"` // 所属专利的公开号
+	PublicationNo       string `gorm:"index:idx_patent_publication,unique;size:32"` // 本次公布的公开号
+	Stage               string // 公布阶段：申请公布、授权公告、更正
+	PublicationDate     string // 公布日
+}
+
+// ParseMultiPublication 解析多次公布的 html 片段
+// 片段的结构不固定，这里逐行用正则找出公开号与日期，阶段优先取行内的文字，没有时根据公开号的类型码推断
+func ParseMultiPublication(database *PatentDatabase, patentPublicationNo, body string) ([]PublicationRecord, error) {
+	doc, err := htmlquery.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := htmlquery.QueryAll(doc, "//tr | //li")
+	if err != nil {
+		return nil, err
+	}
+
+	var records []PublicationRecord
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		text := joinedText(row)
+		publicationNo := database.publicationNoReg.FindString(text)
+		if publicationNo == "" || seen[publicationNo] {
+			continue
+		}
+		seen[publicationNo] = true
+		records = append(records, PublicationRecord{
+			PatentPublicationNo: patentPublicationNo,
+			PublicationNo:       publicationNo,
+			Stage:               publicationStage(database, text, publicationNo),
+			PublicationDate:     normalizePublicationDate(publicationDateReg.FindString(text)),
+		})
+	}
+	return records, nil
+}
+
+// joinedText 用空格连接节点下的所有文本，避免相邻单元格的公开号与日期粘在一起
+func joinedText(node *html.Node) string {
+	var texts []string
+	for _, textNode := range htmlquery.Find(node, ".//text()") {
+		texts = append(texts, strings.TrimSpace(htmlquery.InnerText(textNode)))
+	}
+	return strings.Join(texts, " ")
+}
+
+// publicationStage 根据行内文字或公开号的类型码判断公布阶段
+func publicationStage(database *PatentDatabase, text, publicationNo string) string {
+	switch {
+	case strings.Contains(text, "更正"):
+		return StageCorrection
+	case strings.Contains(text, "授权"):
+		return StageAuthPublication
+	case strings.Contains(text, "申请公布"), strings.Contains(text, "申请公开"):
+		return StageApplyPublication
+	}
+
+	return database.Stage(publicationNo)
+}
+
+// normalizePublicationDate 把 2021.6.8、2021年6月8日 等格式统一为 2021-06-08
+func normalizePublicationDate(date string) string {
+	parsed, err := ParseDate(date)
+	if err != nil {
+		return date
+	}
+	return parsed.Format(DateLayout)
+}
+
+// joinPublicationNo 把多次公布的公开号用 ";" 连接，用于填充 Patent.MultiPublicationNo
+func joinPublicationNo(records []PublicationRecord) string {
+	publicationNos := make([]string, 0, len(records))
+	for _, record := range records {
+		publicationNos = append(publicationNos, record.PublicationNo)
+	}
+	return strings.Join(publicationNos, ";")
+}
@@ -0,0 +1,30 @@
+package spider
+
+import "testing"
+
+func TestParseMultiPublication(t *testing.T) {
+	// 没有阶段文字的列表，只能根据类型码推断阶段
+	body := `<ul>
+<li><a>CN108123456A</a> 2018.6.1</li>
+<li><a>CN108123456B</a> 2020年3月17日</li>
+<li><a>CN108123456B9</a> 2020-05-05</li>
+</ul>`
+	records, err := ParseMultiPublication(patentDatabases[DBCodeSCPD], "CN108123456A", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PublicationRecord{
+		{PublicationNo: "CN108123456A", Stage: StageApplyPublication, PublicationDate: "2018-06-01"},
+		{PublicationNo: "CN108123456B", Stage: StageAuthPublication, PublicationDate: "2020-03-17"},
+		{PublicationNo: "CN108123456B9", Stage: StageCorrection, PublicationDate: "2020-05-05"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("解析出 %d 条, want %d", len(records), len(want))
+	}
+	for i, record := range records {
+		if record.PatentPublicationNo != "CN108123456A" || record.PublicationNo != want[i].PublicationNo ||
+			record.Stage != want[i].Stage || record.PublicationDate != want[i].PublicationDate {
+			t.Errorf("第 %d 条 = %+v, want %+v", i, record, want[i])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package spider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"spider/db"
+)
+
+// 文献类型，由类型码归类
+const (
+	KindApplication = "A" // 发明申请公布
+	KindGrant       = "B" // 发明授权
+	KindUtility     = "U" // 实用新型
+	KindDesign      = "S" // 外观设计
+)
+
+var (
+	// 序号前可能有字母，如美国的再颁专利 USRE48123E、外观设计 USD912345S、植物专利 USPP32123P3
+	publicationNumberReg = regexp.MustCompile(`^([A-Z]{2})((?:RE|PP|D|H|T)?\d{4,12})([A-Z]\d?)$`)
+	// 申请号末尾的校验位，如 CN202110123456.7 中的 .7
+	applicationCheckDigitReg = regexp.MustCompile(`\.[\dX]$`)
+)
+
+// PublicationNumber 是拆分后的公开号，如 CN112926071A 为 CN、112926071、A
+type PublicationNumber struct {
+	Country string // 国家代码
+	Serial  string // 序号，包括序号前的字母，如 RE48123
+	Kind    string // 类型码，如 A、B2、U
+}
+
+// ParsePublicationNo 解析公开号，空白、全角与小写字母会先被规范化
+func ParsePublicationNo(publicationNo string) (PublicationNumber, error) {
+	match := publicationNumberReg.FindStringSubmatch(strings.ToUpper(NormalizeCode(publicationNo)))
+	if match == nil {
+		return PublicationNumber{}, fmt.Errorf("无法解析公开号: %q", publicationNo)
+	}
+	return PublicationNumber{Country: match[1], Serial: match[2], Kind: match[3]}, nil
+}
+
+func (n PublicationNumber) String() string {
+	return n.Country + n.Serial + n.Kind
+}
+
+// KindCategory 把类型码归类为 A、B、U、S，无法归类时返回空
+// 中国 2010 年以前的类型码 C、Y、D 分别对应 B、U、S
+// 美国的再颁专利 E 与授权的植物专利 P2、P3 当作授权，植物专利申请 P1、P4 当作申请公布
+func (n PublicationNumber) KindCategory() string {
+	if n.Kind == "" {
+		return ""
+	}
+	letter := n.Kind[:1]
+	switch n.Country {
+	case "CN":
+		switch letter {
+		case "C":
+			return KindGrant
+		case "Y":
+			return KindUtility
+		case "D":
+			return KindDesign
+		}
+	case "US":
+		switch n.Kind {
+		case "P2", "P3":
+			return KindGrant
+		case "P1", "P4":
+			return KindApplication
+		}
+	}
+	switch letter {
+	case KindApplication, KindGrant, KindUtility, KindDesign:
+		return letter
+	case "E":
+		return KindGrant
+	}
+	return ""
+}
+
+// NormalizeApplicationNo 规范化申请号，作为同一申请各阶段文献的关联键
+// 去掉空白、小数点与校验位，ZL 开头的专利号改为 CN，没有国家代码时使用 country
+func NormalizeApplicationNo(applicationNo, country string) string {
+	no := strings.ToUpper(NormalizeCode(applicationNo))
+	if no == "" {
+		return ""
+	}
+	no = applicationCheckDigitReg.ReplaceAllString(no, "")
+	no = strings.ReplaceAll(no, ".", "")
+	if strings.HasPrefix(no, "ZL") {
+		no = "CN" + no[2:]
+	}
+	if len(no) > 0 && no[0] >= '0' && no[0] <= '9' {
+		no = country + no
+	}
+	return no
+}
+
+// ParseNumbers 拆分公开号，并规范化申请号，公开号无法解析时对应的字段为空
+func (patent *Patent) ParseNumbers() {
+	number, err := ParsePublicationNo(patent.PublicationNo)
+	if err == nil {
+		patent.PublicationCountry, patent.PublicationSerial, patent.PublicationKind = number.Country, number.Serial, number.Kind
+		patent.KindCategory = number.KindCategory()
+	}
+	patent.ApplicationKey = NormalizeApplicationNo(patent.ApplicationNO, patent.PublicationCountry)
+}
+
+// FindApplicationStages 查询同一申请的所有文献，如申请公布与授权公告，按公开号排序
+func FindApplicationStages(applicationNo string) ([]Patent, error) {
+	key := NormalizeApplicationNo(applicationNo, "CN")
+	var patents []Patent
+	err := db.GetDB().Where("application_key = ?", key).Order("publication_no").Find(&patents).Error
+	return patents, err
+}
+
+// BackfillNumbers 为已保存的专利拆分公开号并规范化申请号，每批处理 batch 个专利，返回处理的专利数
+func BackfillNumbers(batch int) (int, error) {
+	var patents []Patent
+	total := 0
+	err := db.GetDB().
+		Select("id", "publication_no", "application_no").
+		FindInBatches(&patents, batch, func(tx *gorm.DB, _ int) error {
+			for i := range patents {
+				patent := &patents[i]
+				patent.ParseNumbers()
+				if err := db.GetDB().Model(&Patent{}).Where("id = ?", patent.ID).Updates(map[string]interface{}{
+					"publication_country": patent.PublicationCountry,
+					"publication_serial":  patent.PublicationSerial,
+					"publication_kind":    patent.PublicationKind,
+					"kind_category":       patent.KindCategory,
+					"application_key":     patent.ApplicationKey,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			total += len(patents)
+			return nil
+		}).Error
+	return total, err
+}
@@ -0,0 +1,59 @@
+package spider
+
+import "testing"
+
+func TestParsePublicationNo(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		want     PublicationNumber
+		category string
+	}{
+		{"CN112926071A", PublicationNumber{"CN", "112926071", "A"}, KindApplication},
+		{"CN 113000001 B", PublicationNumber{"CN", "113000001", "B"}, KindGrant},
+		{"CN212341234U", PublicationNumber{"CN", "212341234", "U"}, KindUtility},
+		{"CN306123456S", PublicationNumber{"CN", "306123456", "S"}, KindDesign},
+		{"CN1234567C", PublicationNumber{"CN", "1234567", "C"}, KindGrant},
+		{"CN2345678Y", PublicationNumber{"CN", "2345678", "Y"}, KindUtility},
+		{"us10956123b2", PublicationNumber{"US", "10956123", "B2"}, KindGrant},
+		{"EP3456789A1", PublicationNumber{"EP", "3456789", "A1"}, KindApplication},
+		{"USRE48123E", PublicationNumber{"US", "RE48123", "E"}, KindGrant},
+		{"USRE48123E1", PublicationNumber{"US", "RE48123", "E1"}, KindGrant},
+		{"USD912345S", PublicationNumber{"US", "D912345", "S"}, KindDesign},
+		{"USPP32123P3", PublicationNumber{"US", "PP32123", "P3"}, KindGrant},
+		{"US20200012345P1", PublicationNumber{"US", "20200012345", "P1"}, KindApplication},
+	} {
+		got, err := ParsePublicationNo(tt.in)
+		if err != nil || got != tt.want || got.KindCategory() != tt.category {
+			t.Errorf("ParsePublicationNo(%q) = %+v %q, %v, want %+v %q", tt.in, got, got.KindCategory(), err, tt.want, tt.category)
+		}
+	}
+	if _, err := ParsePublicationNo("CN11292607"); err == nil {
+		t.Error("没有类型码的公开号应当返回错误")
+	}
+	if got := (PublicationNumber{}).KindCategory(); got != "" {
+		t.Errorf("空类型码的归类 = %q", got)
+	}
+}
+
+func TestNormalizeApplicationNo(t *testing.T) {
+	for _, tt := range []struct{ in, country, want string }{
+		{"CN202110123456.7", "CN", "CN202110123456"},
+		{"ZL 202110123456.7", "CN", "CN202110123456"},
+		{"202110123456.X", "CN", "CN202110123456"},
+		{"US16123456", "US", "US16123456"},
+		{"", "CN", ""},
+	} {
+		if got := NormalizeApplicationNo(tt.in, tt.country); got != tt.want {
+			t.Errorf("NormalizeApplicationNo(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// 同一申请的申请公布与授权公告关联到同一个申请号
+	apply := &Patent{PublicationNo: "CN112926071A", ApplicationNO: "CN202110123456.7"}
+	grant := &Patent{PublicationNo: "CN112926071B", ApplicationNO: "202110123456.7"}
+	apply.ParseNumbers()
+	grant.ParseNumbers()
+	if apply.ApplicationKey != grant.ApplicationKey || apply.KindCategory != KindApplication || grant.KindCategory != KindGrant {
+		t.Errorf("申请公布 %+v 与授权公告 %+v 没有关联", apply, grant)
+	}
+}
@@ -0,0 +1,217 @@
+package spider
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"spider/db"
+)
+
+// 省级与地级行政区划，打包进二进制文件
+//
+//go:embed region/divisions.tsv
+var divisionsTSV []byte
+
+// 简称时去掉的后缀，按顺序尝试
+var divisionSuffixes = []string{"特别行政区", "自治区", "省", "市", "地区", "林区", "盟"}
+
+var (
+	leadingPostcodeReg = regexp.MustCompile(`^\d+`)
+	// 地址中地级之后的区、县、县级市、旗
+	districtReg = regexp.MustCompile(`^\p{Han}{1,8}?(?:区|县|市|旗)`)
+	// 这些不是行政区划，如工业园区、经济开发区
+	notDistrictReg = regexp.MustCompile(`园|开发|高新|工业|保税|经济|科技|路|街|号`)
+)
+
+// division 是一个省级或地级行政区划
+type division struct {
+	Name     string
+	Short    string
+	Province *province
+}
+
+type province struct {
+	division
+	Code   string
+	Cities []*division // 直辖市与港澳台为空
+}
+
+var provinces, provincesByCode = mustLoadDivisions()
+
+func mustLoadDivisions() ([]*province, map[string]*province) {
+	var list []*province
+	byCode := make(map[string]*province)
+	scanner := bufio.NewScanner(bytes.NewReader(divisionsTSV))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, "\t")
+		if len(parts) < 2 {
+			panic(fmt.Sprintf("内置的行政区划数据不合法: %q", line))
+		}
+		p := &province{Code: parts[0], division: newDivision(parts[1])}
+		if len(parts) > 2 {
+			for _, name := range strings.Split(parts[2], ",") {
+				city := newDivision(name)
+				city.Province = p
+				p.Cities = append(p.Cities, &city)
+			}
+		}
+		list = append(list, p)
+		byCode[p.Code] = p
+	}
+	return list, byCode
+}
+
+// newDivision 解析"名称/简称"，没有简称时去掉后缀作为简称
+func newDivision(name string) division {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return division{Name: name[:i], Short: name[i+1:]}
+	}
+	d := division{Name: name, Short: name}
+	for _, suffix := range divisionSuffixes {
+		if short := strings.TrimSuffix(name, suffix); short != name && short != "" {
+			d.Short = short
+			break
+		}
+	}
+	return d
+}
+
+// Region 是从国省代码与地址中得到的省、市、区县
+type Region struct {
+	Province string
+	City     string
+	District string
+}
+
+// ProvinceOfAreaCode 把知网的国省代码（如 32）解析为省级名称，国外的代码返回空
+func ProvinceOfAreaCode(areaCode string) string {
+	if p, ok := provincesByCode[strings.TrimSpace(areaCode)]; ok {
+		return p.Name
+	}
+	return ""
+}
+
+// ParseAddress 从地址中提取省、市、区县，地址可以省略省份或使用简称，如"苏州市工业园区"、"江苏苏州"
+func ParseAddress(address string) Region {
+	rest := leadingPostcodeReg.ReplaceAllString(removeAllBlank(address), "")
+	var region Region
+
+	// 省：全称，其次是地级的全称（地址省略了省份），最后是省的简称
+	var p *province
+	var city *division
+	if p = matchProvince(rest, false); p != nil {
+		rest = strings.TrimPrefix(rest, p.Name)
+	} else if city = matchCity(rest, allCities(), false); city != nil {
+		p = city.Province
+		rest = strings.TrimPrefix(rest, city.Name)
+	} else if p = matchProvince(rest, true); p != nil {
+		rest = strings.TrimPrefix(rest, p.Short)
+	}
+	if p == nil {
+		return region
+	}
+	region.Province = p.Name
+
+	// 市：直辖市的市就是省本身
+	if len(p.Cities) == 0 {
+		region.City = p.Name
+	} else if city == nil {
+		if city = matchCity(rest, p.Cities, false); city != nil {
+			rest = strings.TrimPrefix(rest, city.Name)
+		} else if city = matchCity(rest, p.Cities, true); city != nil {
+			rest = strings.TrimPrefix(rest, city.Short)
+		}
+	}
+	if city != nil {
+		region.City = city.Name
+	}
+	if region.City == "" {
+		return region
+	}
+
+	// 区县
+	if district := districtReg.FindString(rest); district != "" && !notDistrictReg.MatchString(district) {
+		region.District = district
+	}
+	return region
+}
+
+func matchProvince(address string, short bool) *province {
+	for _, p := range provinces {
+		name := p.Name
+		if short {
+			name = p.Short
+		}
+		if strings.HasPrefix(address, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+func matchCity(address string, cities []*division, short bool) *division {
+	var matched *division
+	for _, city := range cities {
+		name := city.Name
+		if short {
+			name = city.Short
+		}
+		// 取最长的匹配，避免"海南藏族自治州"被当作海南省
+		if strings.HasPrefix(address, name) && (matched == nil || len(name) > len(matched.Name)) {
+			matched = city
+		}
+	}
+	return matched
+}
+
+func allCities() []*division {
+	var cities []*division
+	for _, p := range provinces {
+		cities = append(cities, p.Cities...)
+	}
+	return cities
+}
+
+// ResolveRegion 根据国省代码与地址填充 Province、City、District
+// 国省代码是申请人所在省份的权威来源，与地址中的省份不一致时，不使用地址中的市与区县
+func (patent *Patent) ResolveRegion() {
+	region := ParseAddress(patent.ApplicantAddress)
+	if province := ProvinceOfAreaCode(patent.AreaCode); province != "" && province != region.Province {
+		region = Region{Province: province}
+	}
+	patent.Province, patent.City, patent.District = region.Province, region.City, region.District
+}
+
+// BackfillRegions 为已保存的专利填充省、市、区县，每批处理 batch 个专利，返回处理的专利数
+func BackfillRegions(batch int) (int, error) {
+	var patents []Patent
+	total := 0
+	err := db.GetDB().
+		Select("id", "applicant_address", "area_code").
+		FindInBatches(&patents, batch, func(tx *gorm.DB, _ int) error {
+			for i := range patents {
+				patent := &patents[i]
+				patent.ResolveRegion()
+				if err := db.GetDB().Model(&Patent{}).Where("id = ?", patent.ID).Updates(map[string]interface{}{
+					"province": patent.Province,
+					"city":     patent.City,
+					"district": patent.District,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			total += len(patents)
+			return nil
+		}).Error
+	return total, err
+}
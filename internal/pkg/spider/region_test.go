@@ -0,0 +1,39 @@
+package spider
+
+import "testing"
+
+func TestParseAddress(t *testing.T) {
+	for _, tt := range []struct {
+		address string
+		want    Region
+	}{
+		{"310012 浙江省杭州市西湖区文三路 90 号", Region{"浙江省", "杭州市", "西湖区"}},
+		{"215000 江苏省苏州市工业园区星湖街 328 号", Region{"江苏省", "苏州市", ""}},
+		{"北京市海淀区中关村大街 1 号", Region{"北京市", "北京市", "海淀区"}},
+		{"上海浦东新区张江路 100 号", Region{"上海市", "上海市", "浦东新区"}},
+		{"江苏苏州昆山市前进路 1 号", Region{"江苏省", "苏州市", "昆山市"}},
+		{"吉林市船营区某某路 2 号", Region{"吉林省", "吉林市", "船营区"}},
+		{"广西南宁市青秀区民族大道", Region{"广西壮族自治区", "南宁市", "青秀区"}},
+		{"海南藏族自治州共和县", Region{"青海省", "海南藏族自治州", "共和县"}},
+		{"1600 Amphitheatre Parkway, Mountain View, CA", Region{}},
+	} {
+		if got := ParseAddress(tt.address); got != tt.want {
+			t.Errorf("ParseAddress(%q) = %+v, want %+v", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestResolveRegion(t *testing.T) {
+	patent := &Patent{AreaCode: "32", ApplicantAddress: "江苏省苏州市昆山市前进路 1 号"}
+	patent.ResolveRegion()
+	if patent.Province != "江苏省" || patent.City != "苏州市" || patent.District != "昆山市" {
+		t.Errorf("解析结果为 %s %s %s", patent.Province, patent.City, patent.District)
+	}
+
+	// 地址与国省代码不一致时，以国省代码为准
+	patent = &Patent{AreaCode: "33", ApplicantAddress: "江苏省苏州市昆山市前进路 1 号"}
+	patent.ResolveRegion()
+	if patent.Province != "浙江省" || patent.City != "" || patent.District != "" {
+		t.Errorf("解析结果为 %s %s %s", patent.Province, patent.City, patent.District)
+	}
+}
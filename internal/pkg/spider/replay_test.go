@@ -0,0 +1,327 @@
+package spider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fixturesDir = "testdata/fixtures"
+
+// newReplayServer 启动一个本地服务器，按请求的路径与参数返回录制好的响应
+func newReplayServer(t *testing.T, dir string) *httptest.Server {
+	t.Helper()
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byRequestURI := make(map[string]*Fixture, len(fixtures))
+	for _, fixture := range fixtures {
+		req, err := http.NewRequest(fixture.Method, fixture.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byRequestURI[fixture.Method+" "+req.URL.RequestURI()] = fixture
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture, ok := byRequestURI[r.Method+" "+r.URL.RequestURI()]
+		if !ok {
+			t.Errorf("没有录制的请求: %s %s", r.Method, r.URL.RequestURI())
+			http.NotFound(w, r)
+			return
+		}
+		for key, values := range fixture.Header {
+			if key == "Content-Length" {
+				continue
+			}
+			w.Header()[key] = values
+		}
+		w.WriteHeader(fixture.StatusCode)
+		_, _ = w.Write(fixture.Body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// rewriteTransport 把所有请求转发到回放服务器
+type rewriteTransport struct {
+	server *httptest.Server
+}
+
+func (rt *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = rt.server.Listener.Addr().String()
+	return rt.server.Client().Transport.RoundTrip(req)
+}
+
+// newReplaySpider 创建一个所有请求都由回放服务器响应的爬虫
+func newReplaySpider(t *testing.T, th TaskHandler) *Spider {
+	t.Helper()
+	oldHtmlDir, oldFullTextDir := HtmlDir, FullTextDir
+	HtmlDir, FullTextDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { HtmlDir, FullTextDir = oldHtmlDir, oldFullTextDir })
+
+	s := NewSpider(th, 1, 1, 1, time.Millisecond*100, time.Millisecond*200, time.Minute, time.Second*5, "")
+	s.SetTransport(&rewriteTransport{server: newReplayServer(t, fixturesDir)})
+	return s
+}
+
+func TestSpiderReplay(t *testing.T) {
+	th := NewFakeTaskHandler()
+	s := newReplaySpider(t, th)
+	s.SetDiscoverTasks(true)
+	s.SetFullText(time.Millisecond)
+
+	tasks := []Task{
+		{PublicCode: "CN112926071A", Date: "2021-06-08", Code: "I138"},
+		{PublicCode: "CN212341234U", Date: "2021-01-12", Code: "B027"},
+		{PublicCode: "US10956123B2", Date: "2021-03-23", Code: "I140", DBCode: DBCodeSCOD},
+	}
+	for i := range tasks {
+		tasks[i].ID = uint(i + 1)
+		if err := s.Run(context.Background(), &tasks[i]); err != nil {
+			t.Fatalf("%s: %v", tasks[i].PublicCode, err)
+		}
+	}
+	if err := s.WaitPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 全文在专利保存后单独下载，关闭队列等待下载完成
+	if err := s.fullText.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[uint]Patent{
+		1: {
+			Title:                "一种基于深度学习的文本分类方法及系统",
+			ApplicationType:      "发明公开",
+			ApplicationNO:        "CN202110123456.7",
+			ApplicationDate:      "2021-02-01",
+			PublicationNo:        "CN112926071A",
+			ApplyPublicationNo:   "CN112926071A",
+			Applicant:            "杭州某某智能科技有限公司",
+			Inventors:            "张三;李四;王五",
+			MainClassificationNo: "G06F16/35",
+		},
+		2: {
+			Title:                "一种便携式水质检测装置",
+			ApplicationType:      "实用新型",
+			ApplicationNO:        "CN202020987654.3",
+			ApplicationDate:      "2020-06-02",
+			PublicationNo:        "CN212341234U",
+			AuthPublicationNo:    "CN212341234U",
+			Applicant:            "江苏某某环保设备有限公司",
+			Inventors:            "陈七;周八",
+			MainClassificationNo: "G01N33/18",
+		},
+		// 海外专利的标签与中国专利不同
+		3: {
+			Title:                "Method and system for training neural networks",
+			ApplicationNO:        "US16123456",
+			ApplicationDate:      "2018-09-05",
+			PublicationNo:        "US10956123B2",
+			AuthPublicationNo:    "US10956123B2",
+			Applicant:            "EXAMPLE TECHNOLOGIES INC",
+			Inventors:            "SMITH JOHN;DOE JANE",
+			MainClassificationNo: "G06N3/08",
+		},
+	}
+	for taskID, want := range cases {
+		got, ok := th.SavedPatents[taskID]
+		if !ok {
+			t.Errorf("任务 %d 的专利没有保存", taskID)
+			continue
+		}
+		for _, field := range []struct{ name, got, want string }{
+			{"Title", got.Title, want.Title},
+			{"ApplicationType", got.ApplicationType, want.ApplicationType},
+			{"ApplicationNO", got.ApplicationNO, want.ApplicationNO},
+			{"ApplicationDate", got.ApplicationDate, want.ApplicationDate},
+			{"PublicationNo", got.PublicationNo, want.PublicationNo},
+			{"ApplyPublicationNo", got.ApplyPublicationNo, want.ApplyPublicationNo},
+			{"AuthPublicationNo", got.AuthPublicationNo, want.AuthPublicationNo},
+			{"Applicant", got.Applicant, want.Applicant},
+			{"Inventors", got.Inventors, want.Inventors},
+			{"MainClassificationNo", got.MainClassificationNo, want.MainClassificationNo},
+		} {
+			if field.got != field.want {
+				t.Errorf("任务 %d 的 %s = %q, want %q", taskID, field.name, field.got, field.want)
+			}
+		}
+		if got.Abstract == "" || got.Sovereignty == "" {
+			t.Errorf("任务 %d 的摘要或主权项为空", taskID)
+		}
+	}
+
+	if day := th.SavedPatents[2].AuthPublicationDay; day == nil || day.Format(DateLayout) != "2021-01-12" || th.SavedPatents[2].Year != "2021" {
+		t.Errorf("任务 2 的授权公告日解析错误: %v, 年份 %q", day, th.SavedPatents[2].Year)
+	}
+
+	if ipcs := th.SavedPatents[1].IPCs; len(ipcs) != 3 || !ipcs[0].Main || ipcs[0].Code != "G06F16/35" || ipcs[2].Subclass != "G06N" {
+		t.Errorf("任务 1 的 IPC 分类号拆分错误: %+v", ipcs)
+	}
+
+	if got := th.SavedPatents[1]; got.Province != "浙江省" || got.City != "杭州市" || got.District != "西湖区" {
+		t.Errorf("任务 1 的地区解析错误: %s %s %s", got.Province, got.City, got.District)
+	}
+
+	for taskID := range cases {
+		if report := th.ValidationReports[taskID]; report == nil || report.HasErrors() {
+			t.Errorf("任务 %d 的校验报告错误: %v", taskID, report)
+		}
+	}
+
+	// 知网的主权项只有第一项权利要求，拆分后只有一个独立权利要求
+	claims := th.SavedPatents[1].Claims
+	if len(claims) != 1 || !claims[0].Independent || claims[0].Number != 1 ||
+		!strings.HasPrefix(claims[0].Text, "一种基于深度学习的文本分类方法") {
+		t.Errorf("任务 1 的权利要求拆分错误: %+v", claims)
+	}
+
+	if got := th.SavedPatents[3]; got.PublicationCountry != "US" || got.PublicationKind != "B2" || got.KindCategory != KindGrant || got.ApplicationKey != "US16123456" {
+		t.Errorf("任务 3 的公开号拆分错误: %s %s %s %s", got.PublicationCountry, got.PublicationKind, got.KindCategory, got.ApplicationKey)
+	}
+
+	publications := th.SavedPatents[1].Publications
+	if len(publications) != 2 {
+		t.Fatalf("多次公布有 %d 条, want 2", len(publications))
+	}
+	if publications[1].PublicationNo != "CN112926071B" || publications[1].Stage != StageAuthPublication ||
+		publications[1].PublicationDate != "2023-03-10" {
+		t.Errorf("授权公告解析错误: %+v", publications[1])
+	}
+	if got := th.SavedPatents[1].MultiPublicationNo; got != "CN112926071A;CN112926071B" {
+		t.Errorf("MultiPublicationNo = %q", got)
+	}
+
+	// 法律状态事件按公告日排序，转移不影响授权后的有效状态
+	events := th.SavedPatents[1].LegalStatusEvents
+	if len(events) != 4 || events[0].StatusCode != "公开" || events[3].EffectiveDate != "2024-01-05" {
+		t.Errorf("法律状态事件解析错误: %+v", events)
+	}
+	if got := th.SavedPatents[1].LegalStatus; got != LegalStatusValid {
+		t.Errorf("任务 1 的 LegalStatus = %q, want %q", got, LegalStatusValid)
+	}
+	if got := th.SavedPatents[2].LegalStatus; got != LegalStatusInvalid {
+		t.Errorf("任务 2 的 LegalStatus = %q, want %q", got, LegalStatusInvalid)
+	}
+
+	// 引证的非专利文献只保存，不作为任务
+	citations := th.SavedPatents[1].Citations
+	if len(citations) != 4 || citations[2].IsPatent() || citations[3].Type != CitationCiting {
+		t.Errorf("引用关系解析错误: %+v", citations)
+	}
+	if len(th.DiscoveredTasks) != 3 {
+		t.Errorf("发现了 %d 个新任务, want 3: %v", len(th.DiscoveredTasks), th.DiscoveredTasks)
+	}
+
+	// 有 PDF 链接时优先下载 PDF，没有链接的专利不下载
+	patent := th.SavedPatents[1]
+	if patent.FullTextFormat != FullTextPDF || patent.FullTextPath == "" {
+		t.Fatalf("全文没有下载: %q, %q", patent.FullTextFormat, patent.FullTextPath)
+	}
+	content, err := os.ReadFile(patent.FullTextPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if int64(len(content)) != patent.FullTextSize || hex.EncodeToString(sum[:]) != patent.FullTextSha256 {
+		t.Errorf("全文的大小或校验和不正确: %d, %s", patent.FullTextSize, patent.FullTextSha256)
+	}
+	if th.SavedPatents[2].FullTextPath != "" {
+		t.Errorf("任务 2 没有全文链接，却下载了全文: %s", th.SavedPatents[2].FullTextPath)
+	}
+
+	// 规则中没有的标签原样保存，并统计出现次数
+	rawFields, err := DecodeRawFields(th.SavedPatents[3].RawFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priority := ""
+	for _, field := range rawFields {
+		if field.Label == "优先权：" {
+			priority = field.Value
+		}
+	}
+	// 摘要与主权项的值由 XPath 规则取得，row 中的值为空，同样记录
+	if len(rawFields) != 11 || priority != "US62554321 2017-09-06" {
+		t.Errorf("原始字段保存错误: %+v", rawFields)
+	}
+	if err := s.labels.Flush(th); err != nil {
+		t.Fatal(err)
+	}
+	if len(th.UnknownLabels) != 1 || th.UnknownLabels["优先权："] != 1 {
+		t.Errorf("未识别的标签统计错误: %v", th.UnknownLabels)
+	}
+}
+
+// 摘要与主权项中的上下标不会截断文本，多项权利要求按编号拆分
+func TestSpiderReplayRichText(t *testing.T) {
+	for _, tt := range []struct {
+		rendering TextRendering
+		abstract  string
+	}{
+		{RenderPlain, "本发明公开了一种二氧化碳吸附材料，在25℃下对CO2的吸附量为3.2 mmol/g，比表面积为1200 m2/g，可用于烟气中CO2的捕集。"},
+		{RenderMarkup, "本发明公开了一种二氧化碳吸附材料，在25℃下对CO<sub>2</sub>的吸附量为3.2 mmol/g，比表面积为1200 m<sup>2</sup>/g，可用于烟气中CO<sub>2</sub>的捕集。"},
+	} {
+		th := NewFakeTaskHandler()
+		s := newReplaySpider(t, th)
+		cnki := NewCnkiSource()
+		cnki.SetTextRendering(tt.rendering)
+		s.RegisterSource(cnki)
+
+		task := &Task{PublicCode: "CN114345678A", Date: "2022-04-15", Code: "B014"}
+		task.ID = 1
+		if err := s.Run(context.Background(), task); err != nil {
+			t.Fatalf("%s: %v", tt.rendering, err)
+		}
+		got := th.SavedPatents[1]
+		if got == nil {
+			t.Fatalf("%s: 专利没有保存", tt.rendering)
+		}
+		if got.Abstract != tt.abstract {
+			t.Errorf("%s: 摘要 = %q, want %q", tt.rendering, got.Abstract, tt.abstract)
+		}
+		if len(got.Claims) != 2 || !got.Claims[0].Independent || got.Claims[1].DependsOn != "1" ||
+			!strings.HasSuffix(got.Claims[1].Text, "/g。") {
+			t.Errorf("%s: 权利要求拆分错误: %+v", tt.rendering, got.Claims)
+		}
+	}
+}
+
+func TestRecordTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<h1>recorded</h1>"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := &http.Client{Transport: NewRecordTransport(http.DefaultTransport, dir)}
+	res, err := client.Get(server.URL + "/detail?filename=CN1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) != 1 {
+		t.Fatalf("录制了 %d 个请求, want 1", len(fixtures))
+	}
+	if got := string(fixtures[0].Body); got != "<h1>recorded</h1>" {
+		t.Errorf("录制的响应体 = %q", got)
+	}
+	if fixtures[0].URL != server.URL+"/detail?filename=CN1" || fixtures[0].StatusCode != 200 {
+		t.Errorf("录制的请求不正确: %+v", fixtures[0])
+	}
+}
@@ -0,0 +1,249 @@
+package spider
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+
+	"spider/db"
+)
+
+// 默认的知网字段映射规则，打包进二进制文件
+// 知网改了标签时，可以用 --rules 指定新的规则文件，或用 rules push 推送到数据库，不需要重新分发程序
+//
+//go:embed rules/cnki.json
+var defaultCnkiRules []byte
+
+// normalizers 是规则中可用的规范化步骤
+var normalizers = map[string]func(string) string{
+	"trim":            strings.TrimSpace,
+	"upper":           strings.ToUpper,
+	"lower":           strings.ToLower,
+	"remove_spaces":   removeAllBlank,
+	"collapse_spaces": collapseBlank,
+	"trim_semicolons": func(s string) string { return strings.Trim(s, ";；") },
+}
+
+// FieldRule 描述如何得到 Patent 的一个字段
+// 用 Labels 从详情页的 row 中按标签取值，或用 XPath 直接从页面取第一个节点的文本
+// XPath 规则也可以指定 Labels，表示这些 row 的值由 XPath 取得，如"摘要："，row 中不再取值，也不算未识别的标签
+// Render 为 true 时按 TextRendering 提取节点下的全部文本，保留上下标与换行
+type FieldRule struct {
+	Field     string   `json:"field"`               // Patent 的字段名
+	Labels    []string `json:"labels,omitempty"`    // row 中的标签，如"申请日："；与 xpath 一起使用时只用于识别标签
+	XPath     string   `json:"xpath,omitempty"`     // 取值的 XPath
+	Render    bool     `json:"render,omitempty"`    // 是否按 TextRendering 提取全部文本，只用于 XPath
+	Databases []string `json:"databases,omitempty"` // 只对这些数据库生效，为空表示对所有数据库生效
+	Normalize []string `json:"normalize,omitempty"` // 依次执行的规范化步骤，见 normalizers
+	Comment   string   `json:"comment,omitempty"`
+}
+
+// RuleSet 是一个版本的字段映射规则
+type RuleSet struct {
+	Version string      `json:"version"`
+	Fields  []FieldRule `json:"fields"`
+
+	byLabel map[string][]*FieldRule
+}
+
+// ParseRuleSet 解析并校验规则，字段名、规范化步骤与 XPath 都必须合法
+func ParseRuleSet(content []byte) (*RuleSet, error) {
+	rs := &RuleSet{}
+	if err := json.Unmarshal(content, rs); err != nil {
+		return nil, fmt.Errorf("解析字段映射规则失败: %w", err)
+	}
+	if rs.Version == "" {
+		return nil, errors.New("字段映射规则缺少版本号")
+	}
+	patentType := reflect.TypeOf(Patent{})
+	rs.byLabel = make(map[string][]*FieldRule)
+	for i := range rs.Fields {
+		rule := &rs.Fields[i]
+		field, ok := patentType.FieldByName(rule.Field)
+		if !ok || field.Type.Kind() != reflect.String {
+			return nil, fmt.Errorf("字段映射规则中的字段不存在或不是字符串: %s", rule.Field)
+		}
+		if rule.XPath == "" && len(rule.Labels) == 0 {
+			return nil, fmt.Errorf("字段 %s 的规则必须指定 labels 或 xpath", rule.Field)
+		}
+		if rule.Render && rule.XPath == "" {
+			return nil, fmt.Errorf("字段 %s 的 render 只能与 xpath 一起使用", rule.Field)
+		}
+		if rule.XPath != "" {
+			if _, err := xpath.Compile(rule.XPath); err != nil {
+				return nil, fmt.Errorf("字段 %s 的 xpath 不合法: %w", rule.Field, err)
+			}
+		}
+		for _, name := range rule.Normalize {
+			if _, ok := normalizers[name]; !ok {
+				return nil, fmt.Errorf("字段 %s 的规范化步骤不存在: %s", rule.Field, name)
+			}
+		}
+		for _, label := range rule.Labels {
+			rs.byLabel[label] = append(rs.byLabel[label], rule)
+		}
+	}
+	return rs, nil
+}
+
+// LoadRuleSetFile 从文件中读取规则
+func LoadRuleSetFile(path string) (*RuleSet, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRuleSet(content)
+}
+
+// DefaultCnkiRules 返回打包进程序的知网规则原文
+func DefaultCnkiRules() []byte {
+	return defaultCnkiRules
+}
+
+// DefaultCnkiRuleSet 返回打包进程序的知网规则
+func DefaultCnkiRuleSet() *RuleSet {
+	rs, err := ParseRuleSet(defaultCnkiRules)
+	if err != nil {
+		panic(fmt.Sprintf("内置的字段映射规则不合法: %v", err))
+	}
+	return rs
+}
+
+// FillRowField 根据标签填充专利的字段，没有对应的规则时返回 false
+// 值为空或由 XPath 规则取值的标签只识别，不填充
+func (rs *RuleSet) FillRowField(patent *Patent, dbCode, label, value string) bool {
+	known := false
+	for _, rule := range rs.byLabel[label] {
+		if !rule.appliesTo(dbCode) {
+			continue
+		}
+		known = true
+		if value != "" && rule.XPath == "" {
+			rule.set(patent, value)
+		}
+	}
+	return known
+}
+
+// FillXPathFields 根据 XPath 规则从页面中填充专利的字段，rendering 用于 Render 为 true 的规则
+func (rs *RuleSet) FillXPathFields(patent *Patent, dbCode string, doc *html.Node, rendering TextRendering) error {
+	for i := range rs.Fields {
+		rule := &rs.Fields[i]
+		if rule.XPath == "" || !rule.appliesTo(dbCode) {
+			continue
+		}
+		node, err := htmlquery.Query(doc, rule.XPath)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			continue
+		}
+		if rule.Render {
+			rule.set(patent, RenderText(node, rendering))
+		} else {
+			rule.set(patent, htmlquery.InnerText(node))
+		}
+	}
+	return nil
+}
+
+func (rule *FieldRule) appliesTo(dbCode string) bool {
+	if len(rule.Databases) == 0 {
+		return true
+	}
+	for _, database := range rule.Databases {
+		if strings.EqualFold(database, dbCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// set 依次执行规范化步骤后设置字段，字段在解析规则时已经校验过
+func (rule *FieldRule) set(patent *Patent, value string) {
+	for _, name := range rule.Normalize {
+		value = normalizers[name](value)
+	}
+	reflect.ValueOf(patent).Elem().FieldByName(rule.Field).SetString(value)
+}
+
+// RuleRecord 是推送到数据库中的规则，所有爬虫启动时与运行中都会读取最新的一条
+type RuleRecord struct {
+	gorm.Model
+
+	Source  string `gorm:"index;size:32"` // 规则所属的来源，如 cnki
+	Version string `gorm:"size:64"`
+	Content string `gorm:"type:text"`
+}
+
+// PushRuleSet 校验规则后推送到数据库，正在运行的爬虫会在下次检查时切换到这个版本
+func PushRuleSet(source string, content []byte) (*RuleSet, error) {
+	rs, err := ParseRuleSet(content)
+	if err != nil {
+		return nil, err
+	}
+	record := &RuleRecord{Source: source, Version: rs.Version, Content: string(content)}
+	if err := db.GetDB().Create(record).Error; err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// LatestRuleSet 读取数据库中最新推送的规则，没有时返回 nil
+func LatestRuleSet(source string) (*RuleSet, error) {
+	var records []RuleRecord
+	if err := db.GetDB().Where("source = ?", source).Order("id desc").Limit(1).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return ParseRuleSet([]byte(records[0].Content))
+}
+
+// WatchCentralRules 立即读取一次数据库中的规则，之后每隔 interval 检查一次，有新版本时切换
+func (c *CnkiSource) WatchCentralRules(ctx context.Context, interval time.Duration) {
+	c.refreshCentralRules()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.refreshCentralRules()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *CnkiSource) refreshCentralRules() {
+	rs, err := LatestRuleSet(SourceCnki)
+	if err != nil {
+		logrus.Errorf("读取数据库中的字段映射规则失败: %v", err)
+		return
+	}
+	if rs == nil || rs.Version == c.RuleSet().Version {
+		return
+	}
+	logrus.Infof("字段映射规则已切换: %s -> %s", c.RuleSet().Version, rs.Version)
+	c.SetRuleSet(rs)
+}
+
+func collapseBlank(str string) string {
+	return strings.Join(strings.Fields(str), " ")
+}
@@ -0,0 +1,52 @@
+package spider
+
+import "testing"
+
+func TestParseRuleSet(t *testing.T) {
+	for name, content := range map[string]string{
+		"字段不存在":           `{"version": "1", "fields": [{"field": "NotExist", "labels": ["申请日："]}]}`,
+		"字段不是字符串":         `{"version": "1", "fields": [{"field": "FullTextSize", "labels": ["大小："]}]}`,
+		"缺少版本号":           `{"fields": [{"field": "ApplicationDate", "labels": ["申请日："]}]}`,
+		"规范化步骤不存在":        `{"version": "1", "fields": [{"field": "ApplicationDate", "labels": ["申请日："], "normalize": ["nope"]}]}`,
+		"xpath 不合法":       `{"version": "1", "fields": [{"field": "Title", "xpath": "//h1["}]}`,
+		"没有取值方式":          `{"version": "1", "fields": [{"field": "Title"}]}`,
+		"render 没有 xpath": `{"version": "1", "fields": [{"field": "Title", "labels": ["标题："], "render": true}]}`,
+	} {
+		if _, err := ParseRuleSet([]byte(content)); err == nil {
+			t.Errorf("%s: 应当返回错误", name)
+		}
+	}
+}
+
+func TestRuleSetFillRowField(t *testing.T) {
+	// 模拟知网把"申请日："改成了"申请日期："，只需要修改规则
+	rs, err := ParseRuleSet([]byte(`{
+		"version": "test",
+		"fields": [
+			{"field": "ApplicationDate", "labels": ["申请日：", "申请日期："], "normalize": ["trim"]},
+			{"field": "PublicationNo", "labels": ["公开号："], "databases": ["SCOD"], "normalize": ["upper"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patent := &Patent{}
+	if !rs.FillRowField(patent, DBCodeSCPD, "申请日期：", " 2021-02-01 ") || patent.ApplicationDate != "2021-02-01" {
+		t.Errorf("ApplicationDate = %q", patent.ApplicationDate)
+	}
+	// 只对海外专利生效的规则
+	if rs.FillRowField(patent, DBCodeSCPD, "公开号：", "us10956123b2") {
+		t.Error("中国专利不应当使用海外专利的规则")
+	}
+	if !rs.FillRowField(patent, DBCodeSCOD, "公开号：", "us10956123b2") || patent.PublicationNo != "US10956123B2" {
+		t.Errorf("PublicationNo = %q", patent.PublicationNo)
+	}
+	if rs.FillRowField(patent, DBCodeSCPD, "未知标签：", "x") {
+		t.Error("未知标签不应当被填充")
+	}
+	// 值为空的标签只识别，不覆盖已有的值
+	if !rs.FillRowField(patent, DBCodeSCPD, "申请日：", "") || patent.ApplicationDate != "2021-02-01" {
+		t.Errorf("值为空时 ApplicationDate = %q", patent.ApplicationDate)
+	}
+}
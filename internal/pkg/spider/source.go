@@ -0,0 +1,52 @@
+package spider
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultSource 任务没有指定来源时使用的来源
+const DefaultSource = SourceCnki
+
+// Fetcher 负责发送请求，由 Spider 实现
+// Source 通过它请求页面，以共用代理、录制回放与限速
+type Fetcher interface {
+	// GetHtml 请求页面
+	GetHtml(ctx context.Context, url string) (string, error)
+	// GetSecondaryHtml 请求详情页之外的额外内容，请求前会随机睡眠
+	GetSecondaryHtml(ctx context.Context, url string) (string, error)
+}
+
+// Source 是专利数据的来源，如知网
+// 新的专利网站只要实现这个接口并通过 Spider.RegisterSource 注册，就能复用 WorkerPool 与 TaskHandler
+type Source interface {
+	// Name 来源的名称，与 Task.Source 对应
+	Name() string
+	// URL 返回任务对应的详情页地址
+	URL(task *Task) (string, error)
+	// Fetch 请求详情页
+	Fetch(ctx context.Context, f Fetcher, url string) (string, error)
+	// Parse 把详情页解析为专利，需要时可以通过 f 发起额外的请求
+	// NaviCode、Year、Url、Source 等与来源无关的字段由 Spider 填充
+	Parse(ctx context.Context, f Fetcher, task *Task, url, body string) (*Patent, error)
+}
+
+// RegisterSource 注册专利来源，同名的来源会被覆盖
+func (s *Spider) RegisterSource(source Source) {
+	if s.sources == nil {
+		s.sources = make(map[string]Source)
+	}
+	s.sources[source.Name()] = source
+}
+
+// getSource 根据任务的来源获取 Source，为空时使用默认来源
+func (s *Spider) getSource(name string) (Source, error) {
+	if name == "" {
+		name = DefaultSource
+	}
+	source, ok := s.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("未注册的专利来源: %s", name)
+	}
+	return source, nil
+}
@@ -0,0 +1,68 @@
+package spider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeSource 是一个最简单的专利来源，页面内容就是标题
+type fakeSource struct {
+	baseURL string
+}
+
+func (f *fakeSource) Name() string { return "fake" }
+
+func (f *fakeSource) URL(task *Task) (string, error) {
+	return f.baseURL + "/patent/" + task.PublicCode, nil
+}
+
+func (f *fakeSource) Fetch(ctx context.Context, fetcher Fetcher, url string) (string, error) {
+	return fetcher.GetHtml(ctx, url)
+}
+
+func (f *fakeSource) Parse(_ context.Context, _ Fetcher, task *Task, _, body string) (*Patent, error) {
+	return &Patent{
+		Title:              body,
+		PublicationNo:      task.PublicCode,
+		ApplyPublicationNo: task.PublicCode,
+		ApplicationType:    "发明公开",
+		ApplicationDate:    "2021-01-01",
+		PublicationDate:    "2021-06-01",
+		Applicant:          "某某大学",
+		Inventors:          "张三",
+		ApplicationNO:      "CN202110000000.0",
+		ClassificationNO:   "G06F16/35",
+	}, nil
+}
+
+func TestRegisterSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("来自其他网站的专利"))
+	}))
+	defer server.Close()
+
+	th := NewFakeTaskHandler()
+	s := newReplaySpider(t, th)
+	s.SetTransport(http.DefaultTransport)
+	s.RegisterSource(&fakeSource{baseURL: server.URL})
+
+	task := &Task{PublicCode: "CN113000001A", Date: "2021-06-01", Code: "I138", Source: "fake"}
+	task.ID = 1
+	if err := s.Run(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	patent := th.SavedPatents[1]
+	if patent == nil || patent.Source != "fake" || patent.Title != "来自其他网站的专利" || patent.Year != "2021" {
+		t.Errorf("其他来源的专利保存错误: %+v", patent)
+	}
+
+	task.Source = "unknown"
+	if err := s.Run(context.Background(), task); err == nil {
+		t.Error("未注册的来源应当返回错误")
+	}
+}
@@ -1,21 +1,24 @@
 package spider
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
+	"io"
 	"math/rand"
-	"os"
-	"path/filepath"
-	"strings"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/antchfx/htmlquery"
-	"github.com/parnurzeal/gorequest"
 	"github.com/sirupsen/logrus"
-	"go.uber.org/multierr"
 )
 
-const patentPrefix = "https://kns.cnki.net/kcms/detail/detail.aspx?dbcode=SCPD&filename=%s"
+// RequestTimeout 是单个页面请求的最长时间，包括读取响应，避免卡住的连接一直占用 worker
+const RequestTimeout = time.Second * 30
 
 type Spider struct {
 	th                   TaskHandler
@@ -26,6 +29,19 @@ type Spider struct {
 	taskBatch            int           // 每次获取任务的数量
 	taskPoolCap          int           // 任务池容量
 	proxy                string        // 代理
+	shutdownTimeout      time.Duration // 退出时等待进行中的任务与待保存数据的最长时间
+	transport            http.RoundTripper
+	discoverTasks        bool              // 是否把引用关系中的专利加入任务库
+	fullText             *fullTextQueue    // 全文下载队列，为空时不下载全文
+	sources              map[string]Source // 已注册的专利来源
+	labels               *labelCounter     // 未识别标签的统计
+	drift                *DriftMonitor     // 解析异常检测，为空时不检测
+	validator            *Validator        // 校验规则
+	validationPolicy     ValidationPolicy  // 校验出 error 时的处理方式
+	writer               *BulkWriter       // 批量写入，为空时每个专利单独保存
+	html                 HtmlStore         // 详情页 html 的保存方式
+
+	pending sync.WaitGroup // 追踪尚未完成的数据库与 html 写入
 }
 
 func init() {
@@ -33,7 +49,7 @@ func init() {
 }
 
 func NewSpider(th TaskHandler, concurrency, taskBatch, taskPoolCap int,
-	minSleepTime, maxSleepTime, waitForTaskSleepTime time.Duration, proxy string) *Spider {
+	minSleepTime, maxSleepTime, waitForTaskSleepTime, shutdownTimeout time.Duration, proxy string) *Spider {
 	// 校验与修正参数
 	if concurrency < 1 {
 		logrus.Info("并发数不能小于 1，已自动设置为 1")
@@ -78,10 +94,18 @@ func NewSpider(th TaskHandler, concurrency, taskBatch, taskPoolCap int,
 		logrus.Info("等待任务时的睡眠时间不能大于1小时，已自动设置为 1 小时")
 		waitForTaskSleepTime = time.Hour
 	}
+	if shutdownTimeout <= 0 {
+		logrus.Infof("退出等待时间必须大于 0，已自动设置为 %s", DefaultDrainTimeout)
+		shutdownTimeout = DefaultDrainTimeout
+	}
 	logrus.Infof("所有参数如下：\n并发数：%d\n每次获取任务的数量：%d\n任务池容量：%d\n"+
-		"最小睡眠时间：%s\n最大睡眠时间：%s\n等待任务时的睡眠时间：%s",
-		concurrency, taskBatch, taskPoolCap, minSleepTime, maxSleepTime, waitForTaskSleepTime)
-	return &Spider{
+		"最小睡眠时间：%s\n最大睡眠时间：%s\n等待任务时的睡眠时间：%s\n退出等待时间：%s",
+		concurrency, taskBatch, taskPoolCap, minSleepTime, maxSleepTime, waitForTaskSleepTime, shutdownTimeout)
+	transport, err := newTransport(proxy)
+	if err != nil {
+		logrus.Fatalf("代理地址不合法: %v", err)
+	}
+	s := &Spider{
 		th:                   th,
 		concurrency:          concurrency,
 		taskBatch:            taskBatch,
@@ -90,192 +114,319 @@ func NewSpider(th TaskHandler, concurrency, taskBatch, taskPoolCap int,
 		maxSleepTime:         maxSleepTime,
 		waitForTaskSleepTime: waitForTaskSleepTime,
 		proxy:                proxy,
+		shutdownTimeout:      shutdownTimeout,
+		transport:            transport,
+		labels:               newLabelCounter(),
+		validationPolicy:     PolicyReject,
+		html:                 NewDirHtmlStore(HtmlDir),
+	}
+	s.validator, _ = NewValidator()
+	s.RegisterSource(NewCnkiSource())
+	return s
+}
+
+// newTransport 根据代理地址创建 http.Transport，代理为空时不使用任何代理
+func newTransport(proxy string) (*http.Transport, error) {
+	// 关闭长连接，隧道代理只有在新建连接时才会切换 ip
+	transport := &http.Transport{DisableKeepAlives: true}
+	if proxy == "" {
+		return transport, nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return transport, nil
+}
+
+// Transport 返回发送请求所用的 http.RoundTripper
+func (s *Spider) Transport() http.RoundTripper {
+	return s.transport
+}
+
+// pageTransport 返回请求页面所用的 http.RoundTripper，以 WARC 保存时同时把请求与响应写入 WARC
+// 全文下载直接使用 transport，不经过 WARC，以免整个文件读入内存并重复保存
+func (s *Spider) pageTransport() http.RoundTripper {
+	if store, ok := s.html.(*WARCStore); ok {
+		return store.Transport(s.transport)
 	}
+	return s.transport
+}
+
+// SetTransport 替换发送请求所用的 http.RoundTripper，用于录制与回放请求
+func (s *Spider) SetTransport(transport http.RoundTripper) {
+	s.transport = transport
+}
+
+// SetValidation 设置校验规则与校验出 error 时的处理方式
+func (s *Spider) SetValidation(validator *Validator, policy ValidationPolicy) {
+	s.validator = validator
+	s.validationPolicy = policy
+}
+
+// SetBulkWrite 开启批量写入，每 size 个专利或每隔 interval 保存一次，缓冲区满时 worker 阻塞
+// 开启后保存失败不再返回给 worker，只记录日志，任务之后会重新爬取
+func (s *Spider) SetBulkWrite(size int, interval time.Duration, buffer int) {
+	s.writer = NewBulkWriter(s.th, size, interval, buffer)
+}
+
+// SetHtmlStore 设置详情页 html 的保存方式，默认为每个页面一个文件
+// 以 WARC 保存时所有页面请求都会写入 WARC，全文下载除外
+func (s *Spider) SetHtmlStore(store HtmlStore) {
+	s.html = store
+}
+
+// SetDiscoverTasks 设置是否把引用关系中的专利加入任务库
+func (s *Spider) SetDiscoverTasks(discoverTasks bool) {
+	s.discoverTasks = discoverTasks
+}
+
+// ShutdownContext 返回收到退出信号时结束的 ctx，stop 恢复默认的信号处理
+// 与爬取同时运行的后台任务（如检查推送的字段映射规则）应使用它，在开始退出时停止
+func ShutdownContext() (ctx context.Context, stop context.CancelFunc) {
+	// 这里不能加 syscall.SIGHUP，否则会导致终端连接断开后，程序退出（哪怕是后台运行）
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 }
 
 func (s *Spider) GoRun() {
-	logrus.Infof("并发数为 %d", s.concurrency)
-	wp := NewWorkerPool(s.th, s.concurrency, s.taskBatch, s.taskPoolCap, s.Run, s.RandomSleep, s.WaitForTask)
-	wp.Run()
+	ctx, stop := ShutdownContext()
+	defer stop()
+	s.GoRunContext(ctx, stop)
 }
 
-func (s *Spider) Run(task *Task) error {
-	// 解析专利内容
-	patent, err := s.ParseContent(task.Date, task.Code, task.PublicCode)
-	if err != nil {
-		return err
+// GoRunContext 爬取直到 ctx 结束，之后调用 stop 并等待进行中的任务与待保存的数据
+func (s *Spider) GoRunContext(ctx context.Context, stop context.CancelFunc) {
+	logrus.Infof("并发数为 %d", s.concurrency)
+
+	go s.labels.flushLoop(ctx, s.th, DefaultLabelsFlushInterval)
+
+	wp := NewWorkerPool(s.th, s.concurrency, s.taskBatch, s.taskPoolCap, s.Run, s.sleepBeforeTask, s.WaitForTask)
+	wp.Run(ctx)
+	// 恢复默认的信号处理，退出等待期间再次按下 Ctrl-C 可强制退出
+	stop()
+	logrus.Infof("正在等待进行中的任务与待保存的数据，最多等待 %s，再次按下 Ctrl-C 可强制退出", s.shutdownTimeout)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := wp.Drain(drainCtx); err != nil {
+		logrus.Error(err)
 	}
-	// 校验合法性
-	if !patent.Validate() {
-		logrus.Error("数据不合法")
-		logrus.Infof("patent: %+v\n", patent)
-		return err
+	if err := s.WaitPending(drainCtx); err != nil {
+		logrus.Error(err)
 	}
-	// 保存到数据库
-	save := func() {
-		patent.RemoveAllBlank()
-		logrus.Infof("保存专利到数据库中: %s, %s", patent.PublicationNo, patent.Title)
-		if err := s.th.SavePatent(task.ID, patent); err != nil {
+	if s.writer != nil {
+		if err := s.writer.Close(drainCtx); err != nil {
 			logrus.Error(err)
 		}
 	}
-	go save()
-	return nil
-}
-
-func (s *Spider) ParseContent(date, code, publicCode string) (patent *Patent, err error) {
-	url := getPatentURL(publicCode)
-	logrus.Debugf("开始解析 %s %s %s", date, code, url)
-
-	// 请求专利内容 html
-	body, err := s.GetHtml(url)
-	if err != nil {
-		return nil, err
+	if s.fullText != nil {
+		if err := s.fullText.Close(drainCtx); err != nil {
+			logrus.Error(err)
+		}
 	}
-
-	// 保存 html
-	go s.SaveHtml(body, date, code, publicCode)
-
-	patent = &Patent{}
-	patent.NaviCode = code
-	if len(date) >= 4 {
-		patent.Year = date[0:4]
+	// 等待超时后仍在进行的保存会返回 ErrHtmlStoreClosed，不会写入关闭后的归档
+	if err := s.html.Close(); err != nil {
+		logrus.Errorf("关闭 html 归档失败: %v", err)
 	}
-	patent.Url = url
-
-	// 解析 html
-	doc, err := htmlquery.Parse(strings.NewReader(body))
-	if err != nil {
-		return nil, err
+	if err := s.labels.Flush(s.th); err != nil {
+		logrus.Errorf("保存未识别标签的统计失败: %v", err)
 	}
-	if titleNode, err := htmlquery.Query(doc, "//h1//text()"); err != nil {
-		return nil, err
-	} else if titleNode != nil {
-		patent.Title = strings.TrimSpace(htmlquery.InnerText(titleNode))
+	logrus.Info("程序已退出")
+}
+
+// WaitPending 等待所有尚未完成的数据库与 html 写入，ctx 结束时返回错误
+func (s *Spider) WaitPending(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("等待数据保存超时，部分数据可能丢失: %w", ctx.Err())
 	}
+}
 
-	// 有的是在row下，有的是在row的row1和row2下，这么写效率最高
-	rows, err := htmlquery.QueryAll(doc, "//div[@class='row'] | //div[@class='row-1'] | //div[@class='row-2']")
+// goPending 在后台执行 f，并由 WaitPending 追踪其完成情况
+func (s *Spider) goPending(f func()) {
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		f()
+	}()
+}
+
+func (s *Spider) Run(ctx context.Context, task *Task) error {
+	// 解析专利内容
+	patent, err := s.ParseContent(ctx, task)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	for _, row := range rows {
-		// 获取 key, 形如"申请号："
-		key, err := htmlquery.Query(row, "./span[@class='rowtit']/text() | ./span[@class='rowtit2']/text()")
-		if err != nil {
-			return nil, err
-		}
-		if key == nil {
-			continue
+	if s.drift != nil && patent.Source == SourceCnki {
+		s.drift.Observe(patent)
+	}
+	// 校验合法性，报告与专利一起保存在任务中
+	report := s.validator.Validate(patent)
+	if len(report.Issues) > 0 {
+		logrus.Warnf("校验发现问题: %s, %s", patent.PublicationNo, report)
+	}
+	if report.HasErrors() {
+		switch s.validationPolicy {
+		case PolicyReject:
+			logrus.Debugf("patent: %+v", patent)
+			// 专利不保存，只把校验报告保存到任务中
+			if err := s.th.SaveValidationReport(task.ID, report); err != nil {
+				logrus.Errorf("保存校验报告失败: %s, %v", patent.PublicationNo, err)
+			}
+			return fmt.Errorf("数据不合法: %s, %s", patent.PublicationNo, report)
+		case PolicyQuarantine:
+			logrus.Infof("隔离校验不通过的专利: %s", patent.PublicationNo)
+			if err := s.th.QuarantinePatent(task.ID, patent, report); err != nil {
+				return fmt.Errorf("隔离专利失败: %s, %w", patent.PublicationNo, err)
+			}
+			return nil
 		}
-		keyString := strings.TrimSpace(htmlquery.InnerText(key))
-		if keyString == "" {
-			continue
+	}
+	// 保存到数据库，失败时任务不会被标记为完成，之后会重新爬取
+	if s.writer != nil {
+		item := PendingPatent{TaskID: task.ID, Patent: patent, Report: report, OnSaved: func() { s.queueFullText(patent, task) }}
+		if err := s.writer.Add(ctx, item); err != nil {
+			return fmt.Errorf("加入批量写入失败: %s, %w", patent.PublicationNo, err)
 		}
-
-		// 获取 value
-		valueList, err := htmlquery.QueryAll(row, "./p[@class='funds']//text()")
-		if err != nil {
-			return nil, err
+	} else {
+		logrus.Infof("保存专利到数据库中: %s, %s", patent.PublicationNo, patent.Title)
+		if err := s.th.SavePatent(task.ID, patent, report); err != nil {
+			return fmt.Errorf("保存专利失败: %s, %w", patent.PublicationNo, err)
 		}
-		var valueString string
-		for _, value := range valueList {
-			valueString += strings.TrimSpace(htmlquery.InnerText(value))
+		s.queueFullText(patent, task)
+	}
+	if s.discoverTasks {
+		if err := s.th.DiscoverTasks(citedPatentNos(patent.Citations)); err != nil {
+			logrus.Errorf("添加引用关系中的专利到任务库失败: %v", err)
 		}
+	}
+	return nil
+}
 
-		// 根据 key, value 填充 patent
-		patent.FillRowFields(keyString, valueString)
-
+// queueFullText 在专利保存后把全文加入下载队列，下载完成后再更新全文列
+// 未开启全文下载时什么也不做
+func (s *Spider) queueFullText(patent *Patent, task *Task) {
+	if s.fullText != nil {
+		s.fullText.Add(patent, task.Date, task.Code)
 	}
+}
 
-	// 摘要
-	abstract, err := htmlquery.Query(doc, "//div[@class='abstract-text']/text()")
+// ParseContent 根据任务的来源请求并解析专利
+func (s *Spider) ParseContent(ctx context.Context, task *Task) (*Patent, error) {
+	source, err := s.getSource(task.Source)
 	if err != nil {
 		return nil, err
 	}
-	if abstract != nil {
-		patent.Abstract = strings.TrimSpace(htmlquery.InnerText(abstract))
+	url, err := source.URL(task)
+	if err != nil {
+		return nil, err
 	}
+	logrus.Debugf("开始解析 %s %s %s", task.Date, task.Code, url)
 
-	// 主权项
-	sovereignty, err := htmlquery.Query(doc, "//div[@class='claim-text']/text()")
+	// 请求专利内容 html
+	body, err := source.Fetch(ctx, s, url)
 	if err != nil {
 		return nil, err
 	}
-	if sovereignty != nil {
-		patent.Sovereignty = strings.TrimSpace(htmlquery.InnerText(sovereignty))
-	}
 
-	// 融合申请公开号与授权公开号
-	// 注：其实这个号就是 publicCode，但是有的是申请公开号，有的是授权公开号
-	if patent.ApplyPublicationNo != "" {
-		patent.PublicationNo = patent.ApplyPublicationNo
-	}
-	if patent.AuthPublicationNo != "" {
-		patent.PublicationNo = patent.AuthPublicationNo
+	patent, err := source.Parse(ctx, s, task, url, body)
+	if err != nil {
+		// 解析失败的页面同样保存，以任务中的公开号保存
+		s.goPending(func() { s.SaveHtml(body, task.Date, task.Code, task.PublicCode) })
+		// 页面已取回但解析失败，计入解析异常检测，检测只针对知网的页面
+		if s.drift != nil && source.Name() == SourceCnki && ctx.Err() == nil {
+			if database, dbErr := GetPatentDatabase(task.DBCode); dbErr == nil {
+				s.drift.ObserveFailure(database.Code)
+			}
+		}
+		return nil, err
 	}
-	if patent.PublicationNo != publicCode {
-		return nil, fmt.Errorf("融合申请公开号与授权公开号后，与任务中的公开号匹配失败: "+
-			"日期：%s，学科分类%s，任务中的公开号%s，申请公开号：%s ，授权公开号：%s，融合后的公开号：%s",
-			date, code, publicCode, patent.ApplyPublicationNo, patent.AuthPublicationNo, patent.PublicationNo)
+	patent.Source = source.Name()
+	s.labels.Add(patent)
+	patent.NaviCode = task.Code
+	patent.NormalizeText()
+	// 保存 html，跳转到同一申请其他阶段的页面以实际的公开号保存
+	publicationNo := patent.PublicationNo
+	s.goPending(func() { s.SaveHtml(body, task.Date, task.Code, publicationNo) })
+	patent.ParseNumbers()
+	patent.NormalizeDates()
+	patent.ResolveRegion()
+	patent.IPCs = ParseIPCList(patent.PublicationNo, patent.ClassificationNO, patent.MainClassificationNo)
+	patent.Claims = ParseClaims(patent.PublicationNo, patent.Sovereignty)
+	if patent.Year == "" && len(task.Date) >= 4 {
+		patent.Year = task.Date[0:4]
 	}
-
+	patent.Url = url
 	return patent, nil
 }
 
-func (s *Spider) GetHtml(url string) (string, error) {
-	res, body, errs := gorequest.New().Proxy(s.proxy).Get(url).End()
-	if len(errs) > 0 {
-		return "", multierr.Combine(errs...)
+// GetSecondaryHtml 请求详情页动态加载的内容
+// 这是详情页之外的额外请求，请求前同样随机睡眠，避免请求过快
+func (s *Spider) GetSecondaryHtml(ctx context.Context, url string) (string, error) {
+	s.RandomSleep(ctx)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return s.GetHtml(ctx, url)
+}
+
+// GetHtml 请求页面，超过 RequestTimeout 或 ctx 结束时中断请求
+func (s *Spider) GetHtml(ctx context.Context, url string) (string, error) {
+	// 每次请求都使用新的 cookie，不同请求之间互不影响
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Transport: s.pageTransport(), Jar: jar, Timeout: RequestTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
 	}
 	if res.StatusCode != 200 {
-		return "", fmt.Errorf("请求失败: %s", url)
+		return "", fmt.Errorf("请求失败: %s, 状态码 %d", url, res.StatusCode)
 	}
-	return body, nil
+	return string(body), nil
 }
 
 func (s *Spider) SaveHtml(body, date, code, publicCode string) {
-	if err := os.MkdirAll(filepath.Join(HtmlDir, date, code), os.ModePerm); err != nil {
-		logrus.Error(err)
-		return
-	}
-	if err := os.WriteFile(filepath.Join(HtmlDir, date, code, publicCode+".html"), []byte(body), os.ModePerm); err != nil {
-		logrus.Error(err)
-		return
+	if err := s.html.Save(publicCode, date, code, []byte(body)); err != nil {
+		logrus.Errorf("保存 html 失败: %s, %v", publicCode, err)
 	}
 }
 
-func (s *Spider) RandomSleep() {
+func (s *Spider) RandomSleep(ctx context.Context) {
 	// 随机睡眠 minSleepTime ~ maxSleepTime
 	sleepTime := time.Duration(rand.Int63n(int64(s.maxSleepTime-s.minSleepTime))) + s.minSleepTime
-	time.Sleep(sleepTime)
-}
-
-func (s *Spider) WaitForTask() {
-	logrus.Info("没有任务，等待 " + s.waitForTaskSleepTime.String())
-	time.Sleep(s.waitForTaskSleepTime)
-}
-
-func getPatentURL(publicCode string) string {
-	return fmt.Sprintf(patentPrefix, publicCode)
+	sleepWithContext(ctx, sleepTime)
 }
 
-// 获取被 ";" 分隔的发明人，返回前四个
-func getFirstFourAuthor(inventors string) (first, second, third, fourth string) {
-	inventorsList := strings.Split(inventors, ";")
-	length := len(inventorsList)
-	if length >= 1 {
-		first = inventorsList[0]
-	}
-	if length >= 2 {
-		second = inventorsList[1]
-	}
-	if length >= 3 {
-		third = inventorsList[2]
-	}
-	if length >= 4 {
-		fourth = inventorsList[3]
+// sleepBeforeTask 在开始每个任务前执行，解析异常导致暂停时一直等待，之后再随机睡眠
+// 暂停期间收到退出信号时，任务还未开始，会被交还给任务池
+func (s *Spider) sleepBeforeTask(ctx context.Context) {
+	if s.drift != nil {
+		s.drift.WaitIfPaused(ctx)
 	}
+	s.RandomSleep(ctx)
+}
 
-	return
+func (s *Spider) WaitForTask(ctx context.Context) {
+	logrus.Info("没有任务，等待 " + s.waitForTaskSleepTime.String())
+	sleepWithContext(ctx, s.waitForTaskSleepTime)
 }
@@ -0,0 +1,36 @@
+package spider
+
+import (
+	"spider/db"
+)
+
+// Status 是所有爬虫汇总后的运行状态
+type Status struct {
+	TaskTotal      int64          // 任务总数
+	TaskFinished   int64          // 已完成的任务数
+	PatentTotal    int64          // 已保存的专利数
+	InventionTotal int64          // 不同申请号的数量，同一申请的申请公布与授权公告只算一次
+	UnknownLabels  []UnknownLabel // 出现次数最多的未识别标签
+}
+
+// GetStatus 从数据库中统计运行状态，最多返回 topLabels 个未识别标签
+func GetStatus(topLabels int) (*Status, error) {
+	status := &Status{}
+	if err := db.GetDB().Model(&Task{}).Count(&status.TaskTotal).Error; err != nil {
+		return nil, err
+	}
+	if err := db.GetDB().Model(&Task{}).Where("finish = ?", true).Count(&status.TaskFinished).Error; err != nil {
+		return nil, err
+	}
+	if err := db.GetDB().Model(&Patent{}).Count(&status.PatentTotal).Error; err != nil {
+		return nil, err
+	}
+	if err := db.GetDB().Model(&Patent{}).Where("application_key <> ''").
+		Distinct("application_key").Count(&status.InventionTotal).Error; err != nil {
+		return nil, err
+	}
+	if err := db.GetDB().Order("occurrences desc").Limit(topLabels).Find(&status.UnknownLabels).Error; err != nil {
+		return nil, err
+	}
+	return status, nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -19,7 +20,34 @@ var ErrTaskAllFinished = errors.New("所有任务已完成，等待新任务中"
 type TaskHandler interface {
 	RandomTask() (Task, error)
 	RandomBatchTasks(num int) ([]Task, error) // 随机获取至多 num 个任务，返回的任务数量 <= num
-	SavePatent(taskID uint, patent *Patent) error
+	// SavePatent 保存专利并把任务标记为完成，公开号已经存在时记录差异，同样完成任务
+	// report 不为空时同时把校验报告保存到任务中
+	SavePatent(taskID uint, patent *Patent, report *ValidationReport) error
+	SavePatents(batch []PendingPatent) error       // 同 SavePatent，在一个事务中批量保存
+	ReturnTasks(tasks []Task) error                // 交还获取后未开始爬取的任务
+	DiscoverTasks(publicCodes []string) error      // 把新发现的专利加入任务库，已存在的忽略
+	SaveUnknownLabels(labels []UnknownLabel) error // 累加未识别标签的出现次数
+	// SaveValidationReport 把校验报告保存到任务中，没有问题时清空，用于不保存专利的任务
+	SaveValidationReport(taskID uint, report *ValidationReport) error
+	// QuarantinePatent 把校验不通过的专利保存到隔离表，并把任务标记为完成，校验报告同样保存到任务中
+	QuarantinePatent(taskID uint, patent *Patent, report *ValidationReport) error
+	// SaveFullText 在全文下载完成后更新已保存专利的全文路径、大小与校验和
+	SaveFullText(publicationNo, path string, size int64, sha256 string) error
+}
+
+// PendingPatent 是等待保存的专利及其任务
+type PendingPatent struct {
+	TaskID    uint
+	Patent    *Patent
+	CrawledAt time.Time         // 爬取的时间，为空时取保存的时间
+	Report    *ValidationReport // 校验报告，与专利在同一个事务中保存到任务中，为空时不更新
+	OnSaved   func()            // 保存成功后调用，可以为空
+}
+
+func (item PendingPatent) saved() {
+	if item.OnSaved != nil {
+		item.OnSaved()
+	}
 }
 
 // Task 是任务库
@@ -29,22 +57,32 @@ type Task struct {
 	PublicCode string `gorm:"index:idx_public_code,unique"` // 公开号
 	Date       string // 日期
 	Code       string // 学科代码
-	Finish     bool   `gorm:"default:0"` // 是否已经完成
-	CrawlCount int    `gorm:"default:0"` // 总计被爬取的次数
+	Source     string `gorm:"size:32;default:cnki"` // 专利来源，如 cnki
+	DBCode     string `gorm:"size:16;default:SCPD"` // 知网专利数据库代码，如 SCPD、SCOD
+	Finish     bool   `gorm:"default:0"`            // 是否已经完成
+	CrawlCount int    `gorm:"default:0"`            // 总计被爬取的次数
+	Validation string `gorm:"type:text"`            // 最近一次校验的报告，JSON 格式，见 ValidationReport
+	Redirect   string `gorm:"size:32"`              // 页面跳转到了同一申请的其他公开号时为实际的公开号，见 PublicationAlias
 }
 
 func (t Task) String() string {
-	return fmt.Sprintf("Task{ID: %d, 公开号: %s, 日期: %s, 学科分类号: %s, 是否完成: %t, 已爬取次数: %d}",
-		t.ID, t.PublicCode, t.Date, t.Code, t.Finish, t.CrawlCount)
+	return fmt.Sprintf("Task{ID: %d, 来源: %s, 数据库: %s, 公开号: %s, 日期: %s, 学科分类号: %s, 是否完成: %t, 已爬取次数: %d}",
+		t.ID, t.Source, t.DBCode, t.PublicCode, t.Date, t.Code, t.Finish, t.CrawlCount)
 }
 
 type MysqlTaskHandler struct {
+	upsert bool // 公开号已经存在时是否用本次爬取的专利更新，见 SetUpsert
 }
 
-func NewMysqlTaskHandler() TaskHandler {
-	if err := db.GetDB().AutoMigrate(&Task{}, &Patent{}); err != nil {
+func NewMysqlTaskHandler() *MysqlTaskHandler {
+	if err := db.GetDB().AutoMigrate(&Task{}, &Patent{}, &PublicationRecord{}, &LegalStatusEvent{}, &Citation{},
+		&RuleRecord{}, &UnknownLabel{}, &Entity{}, &PatentEntity{}, &PatentIPC{}, &IPCNode{}, &QuarantinedPatent{},
+		&PublicationAlias{}, &Claim{}, &PatentDuplicate{}, &PatentChange{}); err != nil {
 		logrus.Fatal(err)
 	}
+	if err := SeedIPCNodes(db.GetDB()); err != nil {
+		logrus.Fatalf("写入 IPC 层级失败: %v", err)
+	}
 	return &MysqlTaskHandler{}
 }
 
@@ -111,18 +149,314 @@ func (th *MysqlTaskHandler) RandomBatchTasks(num int) ([]Task, error) {
 	return tasksReturn, nil
 }
 
-func (th *MysqlTaskHandler) SavePatent(taskID uint, patent *Patent) error {
-	// 保存专利
-	err := db.GetDB().
+// ReturnTasks 交还未开始爬取的任务
+// 任务本身一直留在任务库中，这里只需撤销获取任务时增加的被爬取次数
+func (th *MysqlTaskHandler) ReturnTasks(tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	tasksID := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		tasksID = append(tasksID, task.ID)
+	}
+	return db.GetDB().Model(&Task{}).
+		Where("id in (?) and finish = ? and crawl_count > 0", tasksID, false).
+		Update("crawl_count", gorm.Expr("crawl_count - ?", 1)).
+		Error
+}
+
+// DiscoverTasks 把引用关系中发现的专利加入任务库
+// 新任务不知道日期与学科分类，这两列留空，数据库根据公开号的国家代码判断
+func (th *MysqlTaskHandler) DiscoverTasks(publicCodes []string) error {
+	if len(publicCodes) == 0 {
+		return nil
+	}
+	tasks := make([]Task, 0, len(publicCodes))
+	for _, publicCode := range publicCodes {
+		tasks = append(tasks, Task{PublicCode: publicCode, DBCode: DBCodeOfPublicationNo(publicCode)})
+	}
+	return db.GetDB().
 		Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "publication_no"}},
+			Columns:   []clause.Column{{Name: "public_code"}},
 			DoNothing: true,
 		}).
-		Create(patent).Error
-	// 更新任务状态
+		Create(&tasks).Error
+}
+
+// SaveUnknownLabels 累加未识别标签的出现次数，并更新示例
+func (th *MysqlTaskHandler) SaveUnknownLabels(labels []UnknownLabel) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	return db.GetDB().
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "label"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"occurrences":    gorm.Expr("occurrences + VALUES(occurrences)"),
+				"example_value":  gorm.Expr("VALUES(example_value)"),
+				"example_patent": gorm.Expr("VALUES(example_patent)"),
+				"last_seen_at":   gorm.Expr("VALUES(last_seen_at)"),
+				"updated_at":     gorm.Expr("VALUES(updated_at)"),
+			}),
+		}).
+		Create(&labels).Error
+}
+
+// SetUpsert 设置公开号已经存在时的处理方式
+// 为 false 时不覆盖，只在 patent_duplicates 中记录差异；为 true 时更新变化的列，并在 patent_changes 中记录每一列的变化
+func (th *MysqlTaskHandler) SetUpsert(upsert bool) {
+	th.upsert = upsert
+}
+
+// SavePatent 在一个事务中保存专利、发明人等关联数据，并把任务标记为完成，任一步失败都会回滚
+// 公开号已经存在时按 SetUpsert 的设置处理，同样完成任务
+func (th *MysqlTaskHandler) SavePatent(taskID uint, patent *Patent, report *ValidationReport) error {
+	return th.SavePatents([]PendingPatent{{TaskID: taskID, Patent: patent, Report: report}})
+}
+
+// SavePatents 在一个事务中用多行语句保存一批专利，并把它们的任务标记为完成，任一步失败都会回滚
+// 公开号已经存在时按 SetUpsert 的设置处理，同一批中重复的公开号只保存第一个，之后的记录差异
+func (th *MysqlTaskHandler) SavePatents(batch []PendingPatent) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	publicationNos := make([]string, 0, len(batch))
+	for _, item := range batch {
+		publicationNos = append(publicationNos, item.Patent.PublicationNo)
+	}
+	return db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var existing []Patent
+		if err := tx.Where("publication_no IN ?", publicationNos).Find(&existing).Error; err != nil {
+			return err
+		}
+		saved := make(map[string]*Patent, len(batch))
+		stored := make(map[string]bool, len(existing)) // 保存在数据库中，而不是同一批中的
+		for i := range existing {
+			saved[existing[i].PublicationNo] = &existing[i]
+			stored[existing[i].PublicationNo] = true
+		}
+
+		var duplicates []*PatentDuplicate
+		var aliases []PublicationAlias
+		// saveExisting 处理公开号已经存在的专利：开启更新模式时更新，否则记录差异
+		saveExisting := func(item PendingPatent, old *Patent) error {
+			patent := item.Patent
+			if th.upsert && stored[patent.PublicationNo] {
+				crawledAt := item.CrawledAt
+				if crawledAt.IsZero() {
+					crawledAt = time.Now()
+				}
+				for i := range patent.Aliases {
+					patent.Aliases[i].PublicationNo = patent.PublicationNo
+				}
+				changed, err := upsertPatent(tx, item.TaskID, old, patent, crawledAt)
+				if err != nil {
+					return err
+				}
+				if changed > 0 {
+					logrus.Infof("更新了已经存在的专利: %s, %d 列有变化", patent.PublicationNo, changed)
+				}
+				// 同一批中之后重复的公开号与更新后的版本比较
+				saved[patent.PublicationNo], stored[patent.PublicationNo] = patent, false
+				return nil
+			}
+			duplicate := NewPatentDuplicate(item.TaskID, old, patent)
+			if duplicate.Fields != "" {
+				logrus.Warnf("专利已经存在，与已保存的版本不同: %s, 不同的列: %s", patent.PublicationNo, duplicate.Fields)
+			}
+			duplicates = append(duplicates, duplicate)
+			// 跳转到已保存专利的任务，仍然记录别名
+			for _, alias := range patent.Aliases {
+				alias.PublicationNo = patent.PublicationNo
+				aliases = append(aliases, alias)
+			}
+			return nil
+		}
+
+		var inserts []PendingPatent
+		taskIDs := make([]uint, 0, len(batch))
+		cleanIDs := make([]uint, 0, len(batch)) // 校验没有问题的任务，清空校验报告
+		reports := make(map[uint]string)        // 校验有问题的任务 -> 校验报告
+		redirects := make(map[uint]string)
+		for _, item := range batch {
+			patent := item.Patent
+			if old, ok := saved[patent.PublicationNo]; ok {
+				if err := saveExisting(item, old); err != nil {
+					return err
+				}
+			} else {
+				saved[patent.PublicationNo] = patent
+				inserts = append(inserts, item)
+			}
+			switch {
+			case item.Report == nil:
+				taskIDs = append(taskIDs, item.TaskID)
+			case len(item.Report.Issues) == 0:
+				cleanIDs = append(cleanIDs, item.TaskID)
+			default:
+				reports[item.TaskID] = item.Report.JSON()
+			}
+			if len(patent.Aliases) > 0 {
+				redirects[item.TaskID] = patent.PublicationNo
+			}
+		}
+
+		if len(inserts) > 0 {
+			// 查询之后其他 worker 或进程可能抢先保存了同一个公开号，这些专利同样按已经存在处理
+			inserted, conflicted, err := insertPatents(tx, inserts)
+			if err != nil {
+				return err
+			}
+			for _, item := range conflicted {
+				old := &Patent{}
+				if err := tx.Where("publication_no = ?", item.Patent.PublicationNo).Take(old).Error; err != nil {
+					return err
+				}
+				logrus.Infof("专利已被其他任务抢先保存: %s", item.Patent.PublicationNo)
+				stored[old.PublicationNo] = true
+				if err := saveExisting(item, old); err != nil {
+					return err
+				}
+			}
+			if err := saveAssociations(tx, inserted); err != nil {
+				return err
+			}
+			// 保存发明人、申请人等
+			if err := SaveEntities(tx, inserted...); err != nil {
+				return err
+			}
+		}
+		if len(duplicates) > 0 {
+			if err := tx.Create(&duplicates).Error; err != nil {
+				return err
+			}
+		}
+		if len(aliases) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&aliases).Error; err != nil {
+				return err
+			}
+		}
+
+		// 更新任务状态与校验报告，跳转的任务记录实际的公开号，不再重试
+		if len(taskIDs) > 0 {
+			if err := tx.Model(&Task{}).Where("id IN ?", taskIDs).Update("finish", true).Error; err != nil {
+				return err
+			}
+		}
+		if len(cleanIDs) > 0 {
+			if err := tx.Model(&Task{}).Where("id IN ?", cleanIDs).
+				Updates(map[string]interface{}{"finish": true, "validation": ""}).Error; err != nil {
+				return err
+			}
+		}
+		for taskID, report := range reports {
+			if err := tx.Model(&Task{}).Where("id = ?", taskID).
+				Updates(map[string]interface{}{"finish": true, "validation": report}).Error; err != nil {
+				return err
+			}
+		}
+		for taskID, publicationNo := range redirects {
+			if err := tx.Model(&Task{}).Where("id = ?", taskID).Update("redirect", publicationNo).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// errInsertConflict 表示多行插入时有公开号已经存在，需要逐个插入
+var errInsertConflict = errors.New("公开号已经存在")
+
+// insertPatents 插入新的专利，返回插入成功的专利与公开号已经存在的专利，不保存关联数据
+// 通常用一条多行语句插入；有冲突时回滚到保存点，再逐个插入找出冲突的专利
+func insertPatents(tx *gorm.DB, items []PendingPatent) (inserted []*Patent, conflicted []PendingPatent, err error) {
+	patents := make([]*Patent, 0, len(items))
+	for _, item := range items {
+		patents = append(patents, item.Patent)
+	}
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&patents)
+		if result.Error != nil {
+			return result.Error
+		}
+		if int(result.RowsAffected) < len(patents) {
+			return errInsertConflict
+		}
+		return nil
+	})
 	if err == nil {
-		return db.GetDB().Model(&Task{}).Where("id = ?", taskID).Update("finish", true).Error
+		return patents, nil, nil
+	}
+	if !errors.Is(err, errInsertConflict) {
+		return nil, nil, err
 	}
+	for _, item := range items {
+		item.Patent.ID = 0
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(item.Patent)
+		if result.Error != nil {
+			return nil, nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			item.Patent.ID = 0
+			conflicted = append(conflicted, item)
+		} else {
+			inserted = append(inserted, item.Patent)
+		}
+	}
+	return inserted, conflicted, nil
+}
+
+// saveAssociations 保存新插入专利的多次公布、法律状态、引用关系、IPC 分类号及其各级节点、权利要求与别名
+func saveAssociations(tx *gorm.DB, patents []*Patent) error {
+	var publications []PublicationRecord
+	var events []LegalStatusEvent
+	var citations []Citation
+	var ipcs []PatentIPC
+	var claims []Claim
+	var aliases []PublicationAlias
+	for _, patent := range patents {
+		for i := range patent.Aliases {
+			patent.Aliases[i].PublicationNo = patent.PublicationNo
+		}
+		publications = append(publications, patent.Publications...)
+		events = append(events, patent.LegalStatusEvents...)
+		citations = append(citations, patent.Citations...)
+		ipcs = append(ipcs, patent.IPCs...)
+		claims = append(claims, patent.Claims...)
+		aliases = append(aliases, patent.Aliases...)
+	}
+	// 分类号各级的节点同样写入 ipc_nodes，已存在的节点不覆盖
+	nodes := IPCNodesOf(ipcs)
+	for _, association := range []interface{}{&publications, &events, &citations, &ipcs, &nodes, &claims, &aliases} {
+		if err := createIfAny(tx.Clauses(clause.OnConflict{DoNothing: true}), association); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (th *MysqlTaskHandler) SaveValidationReport(taskID uint, report *ValidationReport) error {
+	return db.GetDB().Model(&Task{}).Where("id = ?", taskID).Update("validation", report.JSON()).Error
+}
+
+func (th *MysqlTaskHandler) QuarantinePatent(taskID uint, patent *Patent, report *ValidationReport) error {
+	quarantined, err := NewQuarantinedPatent(taskID, patent, report)
+	if err != nil {
+		return err
+	}
+	return db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(quarantined).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Task{}).Where("id = ?", taskID).
+			Updates(map[string]interface{}{"finish": true, "validation": report.JSON()}).Error
+	})
+}
 
-	return err
+func (th *MysqlTaskHandler) SaveFullText(publicationNo, path string, size int64, sha256 string) error {
+	return db.GetDB().Model(&Patent{}).Where("publication_no = ?", publicationNo).Updates(map[string]interface{}{
+		"full_text_path":   path,
+		"full_text_size":   size,
+		"full_text_sha256": sha256,
+	}).Error
 }
@@ -0,0 +1,217 @@
+package spider
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+	"gorm.io/gorm"
+
+	"spider/db"
+)
+
+// 中文前后的半角标点转换为全角，与知网中文页面的写法一致
+var cjkPunctuation = map[rune]rune{
+	',': '，',
+	';': '；',
+	':': '：',
+	'!': '！',
+	'?': '？',
+	'(': '（',
+	')': '）',
+}
+
+// isCJK 判断字符是否为中日韩文字或全角标点，它们之间的空白没有意义
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// collapseSpaces 把连续的空白合并为一个空格，并去掉紧挨着中文的空白
+// 英文单词之间的空格保留，如 "Method and system"
+func collapseSpaces(str string) string {
+	fields := strings.Fields(str)
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			last, _ := utf8.DecodeLastRuneInString(fields[i-1])
+			first, _ := utf8.DecodeRuneInString(field)
+			if !isCJK(last) && !isCJK(first) {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(field)
+	}
+	return b.String()
+}
+
+// NormalizeProse 规范化标题、摘要、地址等自然语言文本
+// 只把全角字母、数字与标点转为半角，之后紧挨着中文的标点再转回全角
+// 不用 NFKC：它会把 m²、H₂O、① 折叠为 m2、H2O、1，丢掉单位与化学式中的上下标
+func NormalizeProse(str string) string {
+	runes := []rune(collapseSpaces(norm.NFC.String(width.Fold.String(str))))
+	for i, r := range runes {
+		full, ok := cjkPunctuation[r]
+		if !ok || (r == ';' && endsWithEntity(runes[:i+1])) {
+			continue
+		}
+		if (i > 0 && isCJK(runes[i-1])) || (i+1 < len(runes) && isCJK(runes[i+1])) {
+			runes[i] = full
+		}
+	}
+	matchBrackets(runes)
+	return string(runes)
+}
+
+// matchBrackets 让成对的括号宽度一致，任一个为全角时两个都用全角，如"（如图1）"
+// 逐个字符判断时右括号旁边是数字，会被当作半角
+func matchBrackets(runes []rune) {
+	var opens []int
+	for i, r := range runes {
+		switch r {
+		case '(', '（':
+			opens = append(opens, i)
+		case ')', '）':
+			if len(opens) == 0 {
+				continue
+			}
+			open := opens[len(opens)-1]
+			opens = opens[:len(opens)-1]
+			if runes[open] == '（' || r == '）' {
+				runes[open], runes[i] = '（', '）'
+			}
+		}
+	}
+}
+
+// 保留标记的文本中转义后的字符，见 RenderMarkup
+var htmlEntityReg = regexp.MustCompile(`&(lt|gt|amp|quot|#34|#39);$`)
+
+// endsWithEntity 判断 runes 是否以转义字符结尾，其中的分号不是标点
+func endsWithEntity(runes []rune) bool {
+	start := len(runes) - 6
+	if start < 0 {
+		start = 0
+	}
+	return htmlEntityReg.MatchString(string(runes[start:]))
+}
+
+// NormalizeNames 规范化以分号分隔的人名或机构名，分号统一为半角，每个名称内的空白同 NormalizeProse
+func NormalizeNames(str string) string {
+	names := strings.FieldsFunc(norm.NFKC.String(str), func(r rune) bool { return r == ';' })
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = collapseSpaces(name); name != "" {
+			result = append(result, name)
+		}
+	}
+	return strings.Join(result, ";")
+}
+
+// NormalizeCode 规范化公开号、分类号、日期等代码，空白全部去掉
+func NormalizeCode(str string) string {
+	return removeAllBlank(norm.NFKC.String(str))
+}
+
+// textField 是一个需要规范化的字段
+type textField struct {
+	column    string
+	value     *string
+	normalize func(string) string
+}
+
+// textFields 返回专利中所有需要规范化的字段及各自的规范化方式
+func (patent *Patent) textFields() []textField {
+	return []textField{
+		{"title", &patent.Title, NormalizeProse},
+		{"url", &patent.Url, strings.TrimSpace},
+		{"navi_code", &patent.NaviCode, NormalizeCode},
+		{"year", &patent.Year, NormalizeCode},
+		{"application_type", &patent.ApplicationType, NormalizeCode},
+		{"application_date", &patent.ApplicationDate, NormalizeCode},
+		{"publication_no", &patent.PublicationNo, NormalizeCode},
+		{"apply_publication_no", &patent.ApplyPublicationNo, NormalizeCode},
+		{"auth_publication_no", &patent.AuthPublicationNo, NormalizeCode},
+		{"multi_publication_no", &patent.MultiPublicationNo, NormalizeCode},
+		{"publication_date", &patent.PublicationDate, NormalizeCode},
+		{"auth_publication_date", &patent.AuthPublicationDate, NormalizeCode},
+		{"applicant", &patent.Applicant, NormalizeNames},
+		{"applicant_address", &patent.ApplicantAddress, NormalizeProse},
+		{"inventors", &patent.Inventors, NormalizeNames},
+		{"application_no", &patent.ApplicationNO, NormalizeCode},
+		{"area_code", &patent.AreaCode, NormalizeCode},
+		{"classification_no", &patent.ClassificationNO, NormalizeCode},
+		{"main_classification_no", &patent.MainClassificationNo, NormalizeCode},
+		{"agency", &patent.Agency, NormalizeNames},
+		{"agent", &patent.Agent, NormalizeNames},
+		{"page", &patent.Page, NormalizeCode},
+		{"abstract", &patent.Abstract, NormalizeRich},
+		{"sovereignty", &patent.Sovereignty, NormalizeRich},
+		{"legal_status", &patent.LegalStatus, NormalizeCode},
+	}
+}
+
+// NormalizeText 按字段规范化专利的文本，取代之前去掉所有空白的做法
+func (patent *Patent) NormalizeText() {
+	for _, field := range patent.textFields() {
+		*field.value = field.normalize(*field.value)
+	}
+}
+
+// restoreSpaces 用 RawFields 中的原始值恢复之前被去掉的空白
+// 只有原始值去掉空白后与当前值相同时才恢复，避免用旧的值覆盖其他方式得到的值
+func (patent *Patent) restoreSpaces(rules *RuleSet) error {
+	rawFields, err := DecodeRawFields(patent.RawFields)
+	if err != nil {
+		return err
+	}
+	raw := &Patent{}
+	for _, field := range rawFields {
+		rules.FillRowField(raw, patent.DBCode, field.Label, field.Value)
+	}
+	rawValues := raw.textFields()
+	for i, field := range patent.textFields() {
+		value := field.normalize(*rawValues[i].value)
+		if value != "" && removeAllBlank(value) == removeAllBlank(*field.value) {
+			*field.value = value
+		}
+	}
+	return nil
+}
+
+// BackfillText 规范化已保存专利的文本，并尽量从 RawFields 中恢复英文单词之间的空格
+// 标题、摘要与主权项不在 RawFields 中，之前去掉的空白无法恢复，只做规范化
+// 每批处理 batch 个专利，返回处理的专利数
+func BackfillText(batch int) (int, error) {
+	rules := DefaultCnkiRuleSet()
+	if latest, err := LatestRuleSet(SourceCnki); err != nil {
+		return 0, err
+	} else if latest != nil {
+		rules = latest
+	}
+
+	var patents []Patent
+	total := 0
+	err := db.GetDB().FindInBatches(&patents, batch, func(tx *gorm.DB, _ int) error {
+		for i := range patents {
+			patent := &patents[i]
+			if err := patent.restoreSpaces(rules); err != nil {
+				return err
+			}
+			patent.NormalizeText()
+			updates := make(map[string]interface{})
+			for _, field := range patent.textFields() {
+				updates[field.column] = *field.value
+			}
+			if err := db.GetDB().Model(&Patent{}).Where("id = ?", patent.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		total += len(patents)
+		return nil
+	}).Error
+	return total, err
+}
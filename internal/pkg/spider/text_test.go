@@ -0,0 +1,54 @@
+package spider
+
+import "testing"
+
+func TestNormalizeText(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		normalize func(string) string
+		in, want  string
+	}{
+		{"英文保留空格", NormalizeProse, "  Method  and\nsystem for training ", "Method and system for training"},
+		{"中文去掉空白", NormalizeProse, "一种 基于深度学习的\n文本分类方法", "一种基于深度学习的文本分类方法"},
+		{"中英混排", NormalizeProse, "基于 BERT 模型的 text classification", "基于BERT模型的text classification"},
+		{"全角字母数字", NormalizeProse, "ＬＥＤ灯，功率１０Ｗ", "LED灯，功率10W"},
+		{"中文旁的标点为全角", NormalizeProse, "一种装置,包括:外壳;以及(盖板)", "一种装置，包括：外壳；以及（盖板）"},
+		{"成对的括号宽度一致", NormalizeProse, "（如图1）所示，权利要求(1-3)", "（如图1）所示，权利要求（1-3）"},
+		{"英文中的括号为半角", NormalizeProse, "a loss (see Fig. 1)", "a loss (see Fig. 1)"},
+		{"英文中的标点为半角", NormalizeProse, "a loss， and weights", "a loss, and weights"},
+		{"保留上下标字符", NormalizeProse, "面积为 10 m²，产物为H₂O与CO₂，见①", "面积为10 m²，产物为H₂O与CO₂，见①"},
+		{"多行文本保留上下标字符", NormalizeRich, "浓度为 10⁻³ mol/L\n\n产物为 ＣＯ₂", "浓度为10⁻³ mol/L\n产物为CO₂"},
+		{"地址", NormalizeProse, "310012 浙江省杭州市西湖区文三路 90 号", "310012浙江省杭州市西湖区文三路90号"},
+		{"人名", NormalizeNames, " SMITH  JOHN ； DOE JANE;", "SMITH JOHN;DOE JANE"},
+		{"中文人名", NormalizeNames, "张 三；李四", "张三;李四"},
+		{"代码", NormalizeCode, " ＣＮ 112926071 A ", "CN112926071A"},
+	} {
+		if got := tt.normalize(tt.in); got != tt.want {
+			t.Errorf("%s: %q -> %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRestoreSpaces(t *testing.T) {
+	// 之前保存的专利去掉了所有空白
+	patent := &Patent{
+		DBCode:    DBCodeSCOD,
+		Applicant: "EXAMPLETECHNOLOGIESINC",
+		Inventors: "SMITHJOHN;DOEJANE",
+		Agent:     "其他方式得到的值",
+		RawFields: encodeRawFields([]RawField{
+			{Label: "申请人/专利权人：", Value: "EXAMPLE TECHNOLOGIES INC"},
+			{Label: "发明人：", Value: "SMITH JOHN;DOE JANE"},
+			{Label: "代理人：", Value: "不一致的值"},
+		}),
+	}
+	if err := patent.restoreSpaces(DefaultCnkiRuleSet()); err != nil {
+		t.Fatal(err)
+	}
+	if patent.Applicant != "EXAMPLE TECHNOLOGIES INC" || patent.Inventors != "SMITH JOHN;DOE JANE" {
+		t.Errorf("空格没有恢复: %q, %q", patent.Applicant, patent.Inventors)
+	}
+	if patent.Agent != "其他方式得到的值" {
+		t.Errorf("与原始值不一致的字段被覆盖: %q", patent.Agent)
+	}
+}
@@ -0,0 +1,321 @@
+package spider
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 校验问题的严重程度，error 按 ValidationPolicy 处理，warning 只记录
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationPolicy 决定校验出 error 时如何处理专利
+type ValidationPolicy string
+
+const (
+	PolicyReject     ValidationPolicy = "reject"     // 不保存，任务算作失败，之后会重新爬取
+	PolicyQuarantine ValidationPolicy = "quarantine" // 保存到隔离表等待人工检查，任务不再爬取
+	PolicyAccept     ValidationPolicy = "accept"     // 照常保存，问题只记录在报告中
+)
+
+// ParseValidationPolicy 解析命令行中的处理方式
+func ParseValidationPolicy(policy string) (ValidationPolicy, error) {
+	switch p := ValidationPolicy(policy); p {
+	case PolicyReject, PolicyQuarantine, PolicyAccept:
+		return p, nil
+	}
+	return "", fmt.Errorf("未知的校验处理方式: %s，可选 reject、quarantine、accept", policy)
+}
+
+var (
+	publicationNoFormatReg = regexp.MustCompile(`^[A-Z]{2}\d{4,12}[A-Z]\d?$`)
+	// 中国申请号：2003 年以后为 12 位数字，之前为 8 位，最后是校验位
+	cnApplicationNoReg = regexp.MustCompile(`^CN(\d{12}|\d{8})\.[\dX]$`)
+)
+
+// ValidationIssue 是一条校验规则发现的问题
+type ValidationIssue struct {
+	Rule     string `json:"rule"`
+	Field    string `json:"field,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// ValidationReport 是一个专利的校验结果，以 JSON 保存在任务中
+type ValidationReport struct {
+	ParserVersion string            `json:"parser_version,omitempty"`
+	Issues        []ValidationIssue `json:"issues,omitempty"`
+}
+
+// HasErrors 返回是否有 error 级别的问题
+func (r *ValidationReport) HasErrors() bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ValidationReport) String() string {
+	if len(r.Issues) == 0 {
+		return "没有问题"
+	}
+	messages := make([]string, 0, len(r.Issues))
+	for _, issue := range r.Issues {
+		messages = append(messages, fmt.Sprintf("[%s] %s: %s", issue.Severity, issue.Rule, issue.Message))
+	}
+	return strings.Join(messages, "；")
+}
+
+// JSON 返回报告的 JSON，没有问题时为空
+func (r *ValidationReport) JSON() string {
+	if len(r.Issues) == 0 {
+		return ""
+	}
+	content, err := json.Marshal(r)
+	if err != nil {
+		// 报告只包含字符串，不会失败
+		panic(err)
+	}
+	return string(content)
+}
+
+// QuarantinedPatent 是校验不通过、等待人工检查的专利
+type QuarantinedPatent struct {
+	gorm.Model
+
+	TaskID        uint   `gorm:"index"`
+	PublicationNo string `gorm:"index;size:32"`
+	ParserVersion string
+	Report        string `gorm:"type:text"`     // 校验报告，JSON 格式
+	Content       string `gorm:"type:longtext"` // 专利本身，JSON 格式
+}
+
+// NewQuarantinedPatent 把专利与校验报告编码为 QuarantinedPatent
+func NewQuarantinedPatent(taskID uint, patent *Patent, report *ValidationReport) (*QuarantinedPatent, error) {
+	content, err := json.Marshal(patent)
+	if err != nil {
+		return nil, err
+	}
+	return &QuarantinedPatent{
+		TaskID:        taskID,
+		PublicationNo: patent.PublicationNo,
+		ParserVersion: patent.ParserVersion,
+		Report:        report.JSON(),
+		Content:       string(content),
+	}, nil
+}
+
+// ValidationRule 是一条命名的校验规则
+type ValidationRule struct {
+	Name  string
+	Check func(patent *Patent) []ValidationIssue
+}
+
+// DefaultValidationRules 是默认启用的校验规则
+var DefaultValidationRules = []ValidationRule{
+	{Name: "min_fields", Check: checkMinFields},
+	{Name: "required_fields", Check: checkRequiredFields},
+	{Name: "publication_no_format", Check: checkPublicationNoFormat},
+	{Name: "application_no_format", Check: checkApplicationNoFormat},
+	{Name: "date_sanity", Check: checkDateSanity},
+	{Name: "applicant_address", Check: checkApplicantAddress},
+}
+
+// Validator 依次执行校验规则
+type Validator struct {
+	rules []ValidationRule
+}
+
+// NewValidator 创建使用默认规则的 Validator，disabled 中的规则不执行
+func NewValidator(disabled ...string) (*Validator, error) {
+	skip := make(map[string]bool)
+	for _, name := range disabled {
+		skip[name] = true
+	}
+	v := &Validator{}
+	for _, rule := range DefaultValidationRules {
+		if skip[rule.Name] {
+			delete(skip, rule.Name)
+			continue
+		}
+		v.rules = append(v.rules, rule)
+	}
+	for name := range skip {
+		return nil, fmt.Errorf("校验规则不存在: %s", name)
+	}
+	return v, nil
+}
+
+// Validate 校验专利，返回所有规则发现的问题
+func (v *Validator) Validate(patent *Patent) *ValidationReport {
+	report := &ValidationReport{ParserVersion: patent.ParserVersion}
+	for _, rule := range v.rules {
+		for _, issue := range rule.Check(patent) {
+			issue.Rule = rule.Name
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+	return report
+}
+
+// Validate 用默认规则校验专利
+func (patent *Patent) Validate() *ValidationReport {
+	v, _ := NewValidator()
+	return v.Validate(patent)
+}
+
+// checkMinFields 标题不能为空，且至少 8 个常见字段不为空，否则多半是页面没有正确解析
+func checkMinFields(patent *Patent) []ValidationIssue {
+	if patent.Title == "" {
+		return []ValidationIssue{{Field: "Title", Severity: SeverityError, Message: "标题为空"}}
+	}
+	notEmptyCount := 0
+	for _, field := range []string{
+		patent.ApplicationType,
+		patent.ApplicationDate,
+		patent.ApplyPublicationNo,
+		patent.AuthPublicationNo,
+		patent.PublicationDate,
+		patent.AuthPublicationDate,
+		patent.Applicant,
+		patent.ApplicantAddress,
+		patent.Inventors,
+		patent.ApplicationNO,
+		patent.AreaCode,
+		patent.ClassificationNO,
+		patent.MainClassificationNo,
+		patent.Agency,
+		patent.Agent,
+		patent.Page,
+	} {
+		if field != "" {
+			notEmptyCount++
+		}
+	}
+	if notEmptyCount < 8 {
+		return []ValidationIssue{{Severity: SeverityError, Message: fmt.Sprintf("只有 %d 个字段不为空，至少应有 8 个", notEmptyCount)}}
+	}
+	return nil
+}
+
+// 各专利类型必须有的字段，海外专利的专利类型为空，只检查公共字段
+var requiredFieldsByType = map[string][]string{
+	"":     {"PublicationNo", "ApplicationNO", "ApplicationDate", "Applicant"},
+	"发明公开": {"ApplyPublicationNo", "PublicationDate", "Inventors"},
+	"发明授权": {"AuthPublicationNo", "AuthPublicationDate", "Inventors"},
+	"实用新型": {"AuthPublicationNo", "AuthPublicationDate", "Inventors"},
+	"外观设计": {"AuthPublicationNo", "AuthPublicationDate"},
+}
+
+func checkRequiredFields(patent *Patent) []ValidationIssue {
+	var issues []ValidationIssue
+	fields := append(append([]string{}, requiredFieldsByType[""]...), requiredFieldsByType[patent.ApplicationType]...)
+	values := map[string]string{
+		"PublicationNo":       patent.PublicationNo,
+		"ApplicationNO":       patent.ApplicationNO,
+		"ApplicationDate":     patent.ApplicationDate,
+		"Applicant":           patent.Applicant,
+		"ApplyPublicationNo":  patent.ApplyPublicationNo,
+		"PublicationDate":     patent.PublicationDate,
+		"AuthPublicationNo":   patent.AuthPublicationNo,
+		"AuthPublicationDate": patent.AuthPublicationDate,
+		"Inventors":           patent.Inventors,
+	}
+	for _, field := range fields {
+		if values[field] == "" {
+			issues = append(issues, ValidationIssue{Field: field, Severity: SeverityError,
+				Message: fmt.Sprintf("%s专利缺少必填字段 %s", patent.ApplicationType, field)})
+		}
+	}
+	return issues
+}
+
+func checkPublicationNoFormat(patent *Patent) []ValidationIssue {
+	var issues []ValidationIssue
+	for _, field := range []struct{ name, value string }{
+		{"PublicationNo", patent.PublicationNo},
+		{"ApplyPublicationNo", patent.ApplyPublicationNo},
+		{"AuthPublicationNo", patent.AuthPublicationNo},
+	} {
+		if field.value != "" && !publicationNoFormatReg.MatchString(field.value) {
+			issues = append(issues, ValidationIssue{Field: field.name, Severity: SeverityError,
+				Message: fmt.Sprintf("公开号格式不正确: %q", field.value)})
+		}
+	}
+	return issues
+}
+
+func checkApplicationNoFormat(patent *Patent) []ValidationIssue {
+	no := removeAllBlank(patent.ApplicationNO)
+	if !strings.HasPrefix(no, "CN") || cnApplicationNoReg.MatchString(no) {
+		return nil
+	}
+	return []ValidationIssue{{Field: "ApplicationNO", Severity: SeverityWarning,
+		Message: fmt.Sprintf("中国申请号格式不正确: %q", patent.ApplicationNO)}}
+}
+
+// checkDateSanity 日期必须能解析，不能晚于今天，申请日不能晚于公开日与授权公告日
+func checkDateSanity(patent *Patent) []ValidationIssue {
+	var issues []ValidationIssue
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	dates := []struct {
+		name  string
+		value string
+		day   *time.Time
+	}{
+		{"ApplicationDate", patent.ApplicationDate, patent.ApplicationDay},
+		{"PublicationDate", patent.PublicationDate, patent.PublicationDay},
+		{"AuthPublicationDate", patent.AuthPublicationDate, patent.AuthPublicationDay},
+	}
+	for _, date := range dates {
+		day := date.day
+		if day == nil && date.value != "" {
+			parsed, err := ParseDate(date.value)
+			if err != nil {
+				issues = append(issues, ValidationIssue{Field: date.name, Severity: SeverityWarning, Message: err.Error()})
+				continue
+			}
+			day = &parsed
+		}
+		if day != nil && day.After(tomorrow) {
+			issues = append(issues, ValidationIssue{Field: date.name, Severity: SeverityError,
+				Message: fmt.Sprintf("日期晚于今天: %s", date.value)})
+		}
+	}
+	if patent.ApplicationDay == nil {
+		return issues
+	}
+	for _, date := range dates[1:] {
+		if date.day != nil && date.day.Before(*patent.ApplicationDay) {
+			issues = append(issues, ValidationIssue{Field: date.name, Severity: SeverityError,
+				Message: fmt.Sprintf("%s %s 早于申请日 %s", date.name, date.value, patent.ApplicationDate)})
+		}
+	}
+	return issues
+}
+
+// checkApplicantAddress 国省代码与地址中的省份应当一致
+func checkApplicantAddress(patent *Patent) []ValidationIssue {
+	province := ProvinceOfAreaCode(patent.AreaCode)
+	if province == "" {
+		return nil
+	}
+	if patent.ApplicantAddress == "" {
+		return []ValidationIssue{{Field: "ApplicantAddress", Severity: SeverityWarning,
+			Message: fmt.Sprintf("国省代码为 %s（%s），但地址为空", patent.AreaCode, province)}}
+	}
+	if addressProvince := ParseAddress(patent.ApplicantAddress).Province; addressProvince != "" && addressProvince != province {
+		return []ValidationIssue{{Field: "ApplicantAddress", Severity: SeverityWarning,
+			Message: fmt.Sprintf("国省代码为 %s（%s），但地址在%s", patent.AreaCode, province, addressProvince)}}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package spider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func validPatent() *Patent {
+	patent := &Patent{
+		Title:               "一种便携式水质检测装置",
+		ApplicationType:     "实用新型",
+		ApplicationNO:       "CN202020987654.3",
+		ApplicationDate:     "2020-06-02",
+		PublicationNo:       "CN212341234U",
+		AuthPublicationNo:   "CN212341234U",
+		AuthPublicationDate: "2021-01-12",
+		Applicant:           "江苏某某环保设备有限公司",
+		ApplicantAddress:    "215000 江苏省苏州市工业园区星湖街 328 号",
+		Inventors:           "陈七;周八",
+		AreaCode:            "32",
+		ClassificationNO:    "G01N33/18",
+	}
+	patent.NormalizeDates()
+	return patent
+}
+
+func TestValidator(t *testing.T) {
+	if report := validPatent().Validate(); len(report.Issues) != 0 {
+		t.Fatalf("合法的专利校验出问题: %s", report)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		modify   func(p *Patent)
+		rule     string
+		severity string
+	}{
+		{"缺少授权公告日", func(p *Patent) { p.AuthPublicationDate, p.AuthPublicationDay = "", nil }, "required_fields", SeverityError},
+		{"公开号格式错误", func(p *Patent) { p.PublicationNo = "CN21234" }, "publication_no_format", SeverityError},
+		{"申请号格式错误", func(p *Patent) { p.ApplicationNO = "CN2020209876543" }, "application_no_format", SeverityWarning},
+		{"授权早于申请", func(p *Patent) { p.ApplicationDate = "2022-01-01"; p.NormalizeDates() }, "date_sanity", SeverityError},
+		{"国省代码与地址不一致", func(p *Patent) { p.AreaCode = "33" }, "applicant_address", SeverityWarning},
+	} {
+		patent := validPatent()
+		tt.modify(patent)
+		report := patent.Validate()
+		found := false
+		for _, issue := range report.Issues {
+			if issue.Rule == tt.rule && issue.Severity == tt.severity {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: 没有发现 %s 级别的 %s 问题: %s", tt.name, tt.severity, tt.rule, report)
+		}
+	}
+
+	v, err := NewValidator("applicant_address")
+	if err != nil {
+		t.Fatal(err)
+	}
+	patent := validPatent()
+	patent.AreaCode = "33"
+	if report := v.Validate(patent); len(report.Issues) != 0 {
+		t.Errorf("禁用的规则仍然执行了: %s", report)
+	}
+	if _, err := NewValidator("no_such_rule"); err == nil {
+		t.Error("禁用不存在的规则应当返回错误")
+	}
+}
+
+func TestValidationPolicy(t *testing.T) {
+	// 标题为空的页面
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, policy := range []ValidationPolicy{PolicyReject, PolicyQuarantine, PolicyAccept} {
+		th := NewFakeTaskHandler()
+		s := newReplaySpider(t, th)
+		s.SetTransport(http.DefaultTransport)
+		s.RegisterSource(&fakeSource{baseURL: server.URL})
+		validator, _ := NewValidator()
+		s.SetValidation(validator, policy)
+
+		task := &Task{PublicCode: "CN113000001A", Date: "2021-06-01", Code: "I138", Source: "fake"}
+		task.ID = 1
+		err := s.Run(context.Background(), task)
+		if waitErr := s.WaitPending(context.Background()); waitErr != nil {
+			t.Fatal(waitErr)
+		}
+		if report := th.ValidationReports[1]; report == nil || !report.HasErrors() {
+			t.Errorf("%s: 校验报告没有保存: %v", policy, report)
+		}
+		saved, quarantined := th.SavedPatents[1] != nil, th.Quarantined[1] != nil
+		switch policy {
+		case PolicyReject:
+			if err == nil || saved || quarantined {
+				t.Errorf("reject: err = %v, 保存 %t, 隔离 %t", err, saved, quarantined)
+			}
+		case PolicyQuarantine:
+			if err != nil || saved || !quarantined {
+				t.Errorf("quarantine: err = %v, 保存 %t, 隔离 %t", err, saved, quarantined)
+			}
+		case PolicyAccept:
+			if err != nil || !saved || quarantined {
+				t.Errorf("accept: err = %v, 保存 %t, 隔离 %t", err, saved, quarantined)
+			}
+		}
+	}
+}
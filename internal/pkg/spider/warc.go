@@ -0,0 +1,437 @@
+package spider
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWARCSize 是单个 WARC 文件的默认大小上限，超过后写入下一个文件
+const DefaultWARCSize = 1 << 30
+
+// warcRecord 是一条 WARC 记录，Block 为记录的内容
+type warcRecord struct {
+	Type         string // warcinfo、request、response、metadata
+	ID           string
+	Date         time.Time
+	TargetURI    string
+	ConcurrentTo string
+	ContentType  string
+	Payload      []byte // HTTP 消息体，用于计算 WARC-Payload-Digest，请求与响应以外为空
+	Block        []byte
+}
+
+// newWARCRecordID 生成 <urn:uuid:...> 形式的记录 ID
+func newWARCRecordID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// warcDigest 返回 sha1:BASE32 形式的摘要
+func warcDigest(content []byte) string {
+	sum := sha1.Sum(content)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// encode 把记录编码为 WARC/1.1 格式，并压缩为一个单独的 gzip 成员
+func (r *warcRecord) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	w := bufio.NewWriter(zw)
+	fmt.Fprintf(w, "WARC/1.1\r\n")
+	fmt.Fprintf(w, "WARC-Type: %s\r\n", r.Type)
+	fmt.Fprintf(w, "WARC-Record-ID: %s\r\n", r.ID)
+	fmt.Fprintf(w, "WARC-Date: %s\r\n", r.Date.UTC().Format(time.RFC3339))
+	if r.TargetURI != "" {
+		fmt.Fprintf(w, "WARC-Target-URI: %s\r\n", r.TargetURI)
+	}
+	if r.ConcurrentTo != "" {
+		fmt.Fprintf(w, "WARC-Concurrent-To: %s\r\n", r.ConcurrentTo)
+	}
+	fmt.Fprintf(w, "Content-Type: %s\r\n", r.ContentType)
+	fmt.Fprintf(w, "WARC-Block-Digest: %s\r\n", warcDigest(r.Block))
+	if r.Type == "request" || r.Type == "response" {
+		fmt.Fprintf(w, "WARC-Payload-Digest: %s\r\n", warcDigest(r.Payload))
+	}
+	fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(r.Block))
+	w.Write(r.Block)
+	w.WriteString("\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// warcEntry 是索引中的一条响应记录
+type warcEntry struct {
+	File   string
+	Offset int64
+	Length int64
+}
+
+// WARCWriter 把记录追加到 crawl-00001.warc.gz 这样的文件中，文件超过 maxSize 后写入下一个
+// 每条记录是一个单独的 gzip 成员，与常见的 WARC 工具兼容；index.tsv 记录每个响应的地址、状态码与位置
+type WARCWriter struct {
+	dir     string
+	maxSize int64
+
+	mu     sync.Mutex
+	n      int      // 当前文件的编号
+	file   *os.File // 当前文件，还没有写入时为空
+	offset int64
+	idx    *os.File
+	index  map[string]warcEntry // 地址 -> 最近一次状态码为 200 的响应
+	closed bool
+}
+
+// OpenWARCWriter 打开目录中的 WARC 文件并读取索引，新的记录写入新的文件
+func OpenWARCWriter(dir string, maxSize int64) (*WARCWriter, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultWARCSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &WARCWriter{dir: dir, maxSize: maxSize, index: make(map[string]warcEntry)}
+	if err := w.loadIndex(); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "crawl-*.warc.gz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "crawl-"), ".warc.gz")); err == nil && n > w.n {
+			w.n = n
+		}
+	}
+	w.idx, err = os.OpenFile(filepath.Join(dir, "index.tsv"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// loadIndex 读取索引，每个地址保留最近一次状态码为 200 的响应
+func (w *WARCWriter) loadIndex() error {
+	f, err := os.Open(filepath.Join(w.dir, "index.tsv"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// 地址、状态码、文件、偏移、长度、时间
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) < 5 || parts[1] != "200" {
+			continue
+		}
+		offset, err1 := strconv.ParseInt(parts[3], 10, 64)
+		length, err2 := strconv.ParseInt(parts[4], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		w.index[parts[0]] = warcEntry{File: parts[2], Offset: offset, Length: length}
+	}
+	return scanner.Err()
+}
+
+// write 写入一条记录，返回记录所在的文件、偏移与长度
+func (w *WARCWriter) write(r *warcRecord) (warcEntry, error) {
+	content, err := r.encode()
+	if err != nil {
+		return warcEntry{}, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return warcEntry{}, ErrHtmlStoreClosed
+	}
+	if w.file != nil && w.offset+int64(len(content)) > w.maxSize {
+		err := w.file.Close()
+		w.file = nil
+		if err != nil {
+			return warcEntry{}, err
+		}
+	}
+	if w.file == nil {
+		if err := w.openNext(); err != nil {
+			return warcEntry{}, err
+		}
+	}
+	if _, err := w.file.Write(content); err != nil {
+		return warcEntry{}, err
+	}
+	entry := warcEntry{File: filepath.Base(w.file.Name()), Offset: w.offset, Length: int64(len(content))}
+	w.offset += entry.Length
+	return entry, nil
+}
+
+// openNext 创建下一个文件，并写入 warcinfo 记录，调用时需持有锁
+func (w *WARCWriter) openNext() error {
+	w.n++
+	name := fmt.Sprintf("crawl-%05d.warc.gz", w.n)
+	file, err := os.OpenFile(filepath.Join(w.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info := &warcRecord{
+		Type:        "warcinfo",
+		ID:          newWARCRecordID(),
+		Date:        time.Now(),
+		ContentType: "application/warc-fields",
+		Block:       []byte("software: spider\r\nformat: WARC File Format 1.1\r\nfilename: " + name + "\r\n"),
+	}
+	content, err := info.encode()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.offset = file, int64(len(content))
+	return nil
+}
+
+// WriteExchange 写入一次请求与响应，响应为空时写入请求与描述错误的 metadata 记录
+func (w *WARCWriter) WriteExchange(req *http.Request, reqBody []byte, res *http.Response, resBody []byte, fetchErr error) error {
+	now := time.Now()
+	uri := req.URL.String()
+	request := &warcRecord{
+		Type:        "request",
+		ID:          newWARCRecordID(),
+		Date:        now,
+		TargetURI:   uri,
+		ContentType: "application/http;msgtype=request",
+		Payload:     reqBody,
+		Block:       httpRequestBlock(req, reqBody),
+	}
+	if _, err := w.write(request); err != nil {
+		return err
+	}
+	if res == nil {
+		_, err := w.write(&warcRecord{
+			Type:         "metadata",
+			ID:           newWARCRecordID(),
+			Date:         now,
+			TargetURI:    uri,
+			ConcurrentTo: request.ID,
+			ContentType:  "application/warc-fields",
+			Block:        []byte(fmt.Sprintf("fetch-error: %s\r\n", strings.ReplaceAll(fetchErr.Error(), "\n", " "))),
+		})
+		return err
+	}
+	entry, err := w.write(&warcRecord{
+		Type:         "response",
+		ID:           newWARCRecordID(),
+		Date:         now,
+		TargetURI:    uri,
+		ConcurrentTo: request.ID,
+		ContentType:  "application/http;msgtype=response",
+		Payload:      resBody,
+		Block:        httpResponseBlock(res, resBody),
+	})
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrHtmlStoreClosed
+	}
+	if _, err := fmt.Fprintf(w.idx, "%s\t%d\t%s\t%d\t%d\t%s\n", uri, res.StatusCode, entry.File, entry.Offset, entry.Length,
+		now.UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusOK {
+		w.index[uri] = entry
+	}
+	return nil
+}
+
+// httpRequestBlock 还原请求的报文
+func httpRequestBlock(req *http.Request, body []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), req.URL.Host)
+	_ = req.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// httpResponseBlock 还原响应的报文，响应体已被解压、分块传输已被合并，相应的头也要去掉
+func httpResponseBlock(res *http.Response, body []byte) []byte {
+	header := res.Header.Clone()
+	header.Del("Transfer-Encoding")
+	if res.Uncompressed {
+		header.Del("Content-Encoding")
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s\r\n", res.Proto, res.Status)
+	_ = header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// Load 读取地址最近一次状态码为 200 的响应体，没有时返回 ErrHtmlNotFound
+func (w *WARCWriter) Load(uri string) ([]byte, error) {
+	w.mu.Lock()
+	entry, ok := w.index[uri]
+	w.mu.Unlock()
+	if !ok {
+		return nil, ErrHtmlNotFound
+	}
+	f, err := os.Open(filepath.Join(w.dir, entry.File))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(io.NewSectionReader(f, entry.Offset, entry.Length))
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(zr)
+	// 跳过 WARC 头，之后是 HTTP 响应
+	if _, err := textproto.NewReader(r).ReadLine(); err != nil {
+		return nil, err
+	}
+	if _, err := textproto.NewReader(r).ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 中的响应失败: %w", entry.File, err)
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	if closeErr := w.idx.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// WARCTransport 在转发请求的同时，把请求与响应以 WARC 格式写入 Writer，包括失败与被拦截的请求
+// 响应体会整个读入内存，只适合页面，不适合全文等大文件
+type WARCTransport struct {
+	Base   http.RoundTripper
+	Writer *WARCWriter
+}
+
+func NewWARCTransport(base http.RoundTripper, writer *WARCWriter) *WARCTransport {
+	return &WARCTransport{Base: base, Writer: writer}
+}
+
+func (wt *WARCTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		reqBody, err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	res, err := wt.Base.RoundTrip(req)
+	if err != nil {
+		return nil, wt.fetchFailed(req, reqBody, err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, wt.fetchFailed(req, reqBody, err)
+	}
+	// 响应体已被读取，需要放回去供调用方使用
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	// 写入失败只记录日志，不影响已经成功的请求
+	if err := wt.Writer.WriteExchange(req, reqBody, res, body, nil); err != nil {
+		logrus.Errorf("写入 WARC 失败: %s, %v", req.URL, err)
+	}
+	return res, nil
+}
+
+// fetchFailed 记录没有得到完整响应的请求，返回原来的错误
+func (wt *WARCTransport) fetchFailed(req *http.Request, reqBody []byte, err error) error {
+	if writeErr := wt.Writer.WriteExchange(req, reqBody, nil, nil, err); writeErr != nil {
+		logrus.Errorf("写入 WARC 失败: %s, %v", req.URL, writeErr)
+	}
+	return err
+}
+
+// WARCStore 是以 WARC 保存时的 HtmlStore，页面已经由 WARCTransport 写入，Save 什么也不做
+// 按公开号读取时查找对应数据库详情页的响应
+type WARCStore struct {
+	writer *WARCWriter
+}
+
+func NewWARCStore(writer *WARCWriter) *WARCStore {
+	return &WARCStore{writer: writer}
+}
+
+// Transport 返回把请求与响应写入 WARC 的 http.RoundTripper
+func (s *WARCStore) Transport(base http.RoundTripper) http.RoundTripper {
+	return NewWARCTransport(base, s.writer)
+}
+
+func (s *WARCStore) Save(_, _, _ string, _ []byte) error {
+	return nil
+}
+
+func (s *WARCStore) Load(publicationNo string) ([]byte, error) {
+	database, err := GetPatentDatabase(DBCodeOfPublicationNo(publicationNo))
+	if err != nil {
+		return nil, err
+	}
+	return s.writer.Load(database.DetailURL(publicationNo))
+}
+
+func (s *WARCStore) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,171 @@
+package spider
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readWARC 读取 WARC 文件中的所有记录，返回各记录的头与内容
+func readWARC(t *testing.T, path string) ([]textproto.MIMEHeader, [][]byte) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := textproto.NewReader(bufio.NewReader(zr))
+	var headers []textproto.MIMEHeader
+	var blocks [][]byte
+	for {
+		version, err := r.ReadLine()
+		if err == io.EOF {
+			return headers, blocks
+		}
+		if err != nil || version != "WARC/1.1" {
+			t.Fatalf("记录开头错误: %q, %v", version, err)
+		}
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		block := make([]byte, length+4)
+		if _, err := io.ReadFull(r.R, block); err != nil {
+			t.Fatal(err)
+		}
+		if string(block[length:]) != "\r\n\r\n" {
+			t.Fatalf("记录结尾错误: %q", block[length:])
+		}
+		headers, blocks = append(headers, header), append(blocks, block[:length])
+	}
+}
+
+func TestWARCTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Path == "/blocked" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("<h1>访问过于频繁</h1>"))
+			return
+		}
+		_, _ = w.Write([]byte("<h1>专利</h1>"))
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	dir := t.TempDir()
+	writer, err := OpenWARCWriter(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: NewWARCTransport(http.DefaultTransport, writer)}
+	for _, url := range []string{server.URL + "/detail?filename=CN1", server.URL + "/blocked", closed.URL + "/detail"} {
+		res, err := client.Get(url)
+		if err != nil {
+			continue
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if len(body) == 0 {
+			t.Errorf("%s: 调用方读到的响应体为空", url)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, blocks := readWARC(t, filepath.Join(dir, "crawl-00001.warc.gz"))
+	var types []string
+	for _, header := range headers {
+		types = append(types, header.Get("WARC-Type"))
+	}
+	if strings.Join(types, ",") != "warcinfo,request,response,request,response,request,metadata" {
+		t.Fatalf("记录类型错误: %v", types)
+	}
+	if headers[2].Get("WARC-Concurrent-To") != headers[1].Get("WARC-Record-ID") ||
+		headers[2].Get("WARC-Target-URI") != server.URL+"/detail?filename=CN1" {
+		t.Errorf("响应记录的头错误: %v", headers[2])
+	}
+	if got, want := headers[2].Get("WARC-Payload-Digest"), warcDigest([]byte("<h1>专利</h1>")); got != want {
+		t.Errorf("WARC-Payload-Digest = %s, want %s", got, want)
+	}
+	if got := headers[2].Get("WARC-Block-Digest"); got != warcDigest(blocks[2]) {
+		t.Errorf("WARC-Block-Digest = %s", got)
+	}
+	if !strings.HasPrefix(string(blocks[1]), "GET /detail?filename=CN1 HTTP/1.1\r\n") {
+		t.Errorf("请求记录错误: %q", blocks[1])
+	}
+	// 被拦截的响应同样保存状态码与头
+	if !strings.HasPrefix(string(blocks[4]), "HTTP/1.1 403 Forbidden\r\n") || !strings.Contains(string(blocks[4]), "Content-Type: text/html") {
+		t.Errorf("被拦截的响应记录错误: %q", blocks[4])
+	}
+	if !strings.HasPrefix(string(blocks[6]), "fetch-error: ") {
+		t.Errorf("失败的请求没有记录错误: %q", blocks[6])
+	}
+
+	// 重新打开后从索引中读取，只返回状态码为 200 的响应
+	writer, err = OpenWARCWriter(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if body, err := writer.Load(server.URL + "/detail?filename=CN1"); err != nil || string(body) != "<h1>专利</h1>" {
+		t.Errorf("读取响应错误: %q, %v", body, err)
+	}
+	if _, err := writer.Load(server.URL + "/blocked"); !errors.Is(err, ErrHtmlNotFound) {
+		t.Errorf("被拦截的响应不应当作为页面返回: %v", err)
+	}
+}
+
+func TestWARCStoreReplay(t *testing.T) {
+	th := NewFakeTaskHandler()
+	s := newReplaySpider(t, th)
+	store, err := NewHtmlStore(HtmlStoreWARC, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s.SetHtmlStore(store)
+	s.SetFullText(time.Millisecond)
+
+	task := &Task{PublicCode: "CN112926071A", Date: "2021-06-08", Code: "I138"}
+	task.ID = 1
+	if err := s.Run(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.fullText.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	body, err := store.Load("CN112926071A")
+	if err != nil || !strings.Contains(string(body), "一种基于深度学习的文本分类方法及系统") {
+		t.Errorf("按公开号读取详情页错误: %v", err)
+	}
+	// 全文下载不经过 WARC
+	patent := th.SavedPatents[1]
+	if patent.FullTextPath == "" {
+		t.Fatal("全文没有下载")
+	}
+	if _, err := store.(*WARCStore).writer.Load(patent.FullTextUrl); !errors.Is(err, ErrHtmlNotFound) {
+		t.Errorf("全文不应写入 WARC: %v", err)
+	}
+}
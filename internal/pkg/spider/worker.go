@@ -1,17 +1,21 @@
 package spider
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
 )
 
-const DefaultTasksChanCap = 50 // task 队列容量
+const (
+	DefaultTasksChanCap = 50               // task 队列容量
+	DefaultDrainTimeout = time.Second * 30 // 退出时等待进行中任务的最长时间
+)
 
 type WorkerPool struct {
 	th          TaskHandler // 获取与更新任务的 handler
@@ -19,14 +23,22 @@ type WorkerPool struct {
 	taskBatch   int         // 每批次获取的任务数量
 	taskChanCap int         // task 任务池容量
 
-	workerFunc           func(task *Task) error // worker 睡眠函数
-	workerSleepFunc      func()
-	taskHandlerSleepFunc func()
+	workerFunc           func(ctx context.Context, task *Task) error // worker 执行函数
+	workerSleepFunc      func(ctx context.Context)                   // worker 睡眠函数
+	taskHandlerSleepFunc func(ctx context.Context)                   // 没有任务时的睡眠函数
 	tasksChan            chan Task
+
+	// 传给 workerFunc 的 ctx，收到退出信号时不取消，进行中的任务可以完成；等待超时后才取消，中断卡住的请求
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+
+	wg        sync.WaitGroup // 追踪 producer 与所有 worker
+	mu        sync.Mutex
+	unstarted []Task // 已出队但还没开始爬取就收到退出信号的任务
 }
 
-func NewWorkerPool(th TaskHandler, workerNum int, taskBatch, taskChanCap int, workerFunc func(task *Task) error,
-	workerSleepFunc func(), taskHandlerSleepFunc func()) *WorkerPool {
+func NewWorkerPool(th TaskHandler, workerNum int, taskBatch, taskChanCap int, workerFunc func(ctx context.Context, task *Task) error,
+	workerSleepFunc func(ctx context.Context), taskHandlerSleepFunc func(ctx context.Context)) *WorkerPool {
 	wp := &WorkerPool{
 		th:                   th,
 		workerNum:            workerNum,
@@ -39,48 +51,70 @@ func NewWorkerPool(th TaskHandler, workerNum int, taskBatch, taskChanCap int, wo
 		taskChanCap = DefaultTasksChanCap
 	}
 	wp.tasksChan = make(chan Task, taskChanCap)
+	wp.workCtx, wp.cancelWork = context.WithCancel(context.Background())
 
 	return wp
 }
 
-// AddTasks 不断地增加任务
-func (wp *WorkerPool) AddTasks(th TaskHandler, num int) error {
+// AddTasks 不断地增加任务，ctx 结束时停止入队，没能入队的任务记为未开始
+func (wp *WorkerPool) AddTasks(ctx context.Context, th TaskHandler, num int) error {
 	tasks, err := th.RandomBatchTasks(num)
 	if err != nil {
 		// 如果获取到的任务是空，则 sleep 一段时间
 		if errors.Is(err, ErrTaskAllFinished) {
-			wp.taskHandlerSleepFunc()
+			wp.taskHandlerSleepFunc(ctx)
 		}
 		return err
 	}
-	for _, task := range tasks {
+	for i, task := range tasks {
 		//logrus.Info("任务入队: ", task)
 		// 如果任务过多会自动阻塞
-		wp.tasksChan <- task
+		select {
+		case wp.tasksChan <- task:
+		case <-ctx.Done():
+			wp.addUnstarted(tasks[i:]...)
+			return ctx.Err()
+		}
 	}
 	logrus.Info("当前批次任务已全部入队")
 	return nil
 }
 
-// GetTask 获取任务
-func (wp *WorkerPool) GetTask() Task {
-	return <-wp.tasksChan
+// GetTask 获取任务，ctx 结束时返回 false
+func (wp *WorkerPool) GetTask(ctx context.Context) (Task, bool) {
+	// 优先响应退出信号，避免在退出时继续从队列中取任务
+	if ctx.Err() != nil {
+		return Task{}, false
+	}
+	select {
+	case task := <-wp.tasksChan:
+		return task, true
+	case <-ctx.Done():
+		return Task{}, false
+	}
 }
 
-func (wp *WorkerPool) Run() {
-	continuousErrCount := 0
+// Run 启动 producer 与 worker，阻塞直到 ctx 结束
+// ctx 结束后不再获取新任务，之后应调用 Drain 等待进行中的任务
+func (wp *WorkerPool) Run(ctx context.Context) {
 	// 1. 不断往队列中塞任务
+	wp.wg.Add(1)
 	go func() {
-		for {
+		defer wp.wg.Done()
+		continuousErrCount := 0
+		for ctx.Err() == nil {
 			if continuousErrCount > 600 {
 				logrus.Error("连续600次错误，退出")
 				os.Exit(1)
 			}
 			logrus.Info("获取下一批次任务")
-			if err := wp.AddTasks(wp.th, wp.taskBatch); err != nil {
+			if err := wp.AddTasks(ctx, wp.th, wp.taskBatch); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				logrus.Error("获取任务失败: ", err)
 				continuousErrCount += 1
-				time.Sleep(time.Second)
+				sleepWithContext(ctx, time.Second)
 				continue
 			}
 			continuousErrCount = 0
@@ -89,15 +123,25 @@ func (wp *WorkerPool) Run() {
 
 	// 2. 启动爬虫
 	for i := 0; i < wp.workerNum; i++ {
+		wp.wg.Add(1)
 		go func() {
+			defer wp.wg.Done()
 			for {
 				// 获取任务，如果任务过少会自动阻塞
-				task := wp.GetTask()
+				task, ok := wp.GetTask(ctx)
+				if !ok {
+					return
+				}
 				// 自动睡眠一段时间
-				wp.workerSleepFunc()
+				wp.workerSleepFunc(ctx)
+				// 睡眠期间收到退出信号，任务还未开始，交还给任务池
+				if ctx.Err() != nil {
+					wp.addUnstarted(task)
+					return
+				}
 				logrus.Infof("开始爬取，任务: %v", task)
 				// 执行爬虫任务
-				if err := wp.workerFunc(&task); err != nil {
+				if err := wp.workerFunc(wp.workCtx, &task); err != nil {
 					logrus.Error("爬取任务失败: ", err)
 					continue
 				}
@@ -105,18 +149,64 @@ func (wp *WorkerPool) Run() {
 		}()
 	}
 
-	sigs := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
-	// 这里不能加 syscall.SIGHUP，否则会导致终端连接断开后，程序退出（哪怕是后台运行）
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	<-ctx.Done()
+	logrus.Info("检测到退出信号，停止获取新任务")
+}
+
+// Drain 等待进行中的任务完成，并把队列中未开始的任务交还给任务池
+// 超过 ctx 的截止时间后取消进行中的任务并不再等待，但仍会交还未开始的任务
+func (wp *WorkerPool) Drain(ctx context.Context) error {
+	defer wp.cancelWork()
+	done := make(chan struct{})
 	go func() {
-		sig := <-sigs
-		fmt.Println()
-		fmt.Println(sig)
-		done <- true
+		wp.wg.Wait()
+		close(done)
 	}()
 
-	//logrus.Info("等待退出信号")
-	<-done
-	logrus.Info("检测到退出信号，退出")
+	var err error
+	select {
+	case <-done:
+		logrus.Info("进行中的任务已全部完成")
+	case <-ctx.Done():
+		err = fmt.Errorf("等待进行中的任务超时: %w", ctx.Err())
+		wp.cancelWork()
+	}
+
+	// 取出队列中剩余的任务
+	tasks := wp.takeUnstarted()
+	for len(wp.tasksChan) > 0 {
+		tasks = append(tasks, <-wp.tasksChan)
+	}
+	if len(tasks) == 0 {
+		return err
+	}
+	logrus.Infof("交还 %d 个未开始的任务", len(tasks))
+	if returnErr := wp.th.ReturnTasks(tasks); returnErr != nil {
+		err = multierr.Append(err, fmt.Errorf("交还未开始的任务失败: %w", returnErr))
+	}
+	return err
+}
+
+func (wp *WorkerPool) addUnstarted(tasks ...Task) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.unstarted = append(wp.unstarted, tasks...)
+}
+
+func (wp *WorkerPool) takeUnstarted() []Task {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	tasks := wp.unstarted
+	wp.unstarted = nil
+	return tasks
+}
+
+// sleepWithContext 睡眠 d，ctx 结束时提前返回
+func sleepWithContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
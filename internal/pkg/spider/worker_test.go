@@ -1,6 +1,8 @@
 package spider
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -8,17 +10,74 @@ import (
 )
 
 func TestWorker(t *testing.T) {
-	workerFunc := func(task *Task) error {
+	workerFunc := func(_ context.Context, task *Task) error {
 		logrus.Info("假装正在爬取任务: ", task)
 		return nil
 	}
-	workerSleepFunc := func() {
-		time.Sleep(time.Second)
+	workerSleepFunc := func(ctx context.Context) {
+		sleepWithContext(ctx, time.Second)
 	}
-	taskHandlerSleepFunc := func() {
+	taskHandlerSleepFunc := func(ctx context.Context) {
 		logrus.Info("未检测到任务，睡眠 10 秒")
-		time.Sleep(time.Second * 10)
+		sleepWithContext(ctx, time.Second*10)
+	}
+	th := NewFakeTaskHandler()
+	wp := NewWorkerPool(th, 5, 10, 50, workerFunc, workerSleepFunc, taskHandlerSleepFunc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	wp.Run(ctx)
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer drainCancel()
+	if err := wp.Drain(drainCtx); err != nil {
+		t.Fatal(err)
+	}
+	// 队列是满的，退出时应当交还未开始的任务
+	if len(th.ReturnedTasks) == 0 {
+		t.Error("退出时没有交还未开始的任务")
+	}
+	if len(wp.tasksChan) != 0 {
+		t.Errorf("退出后队列中仍有 %d 个任务", len(wp.tasksChan))
+	}
+}
+
+// 收到退出信号后进行中的任务继续执行，等待超时后才被取消
+func TestWorkerDrainCancel(t *testing.T) {
+	started := make(chan struct{}, 1)
+	cancelled := make(chan error, 1)
+	workerFunc := func(ctx context.Context, task *Task) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return ctx.Err()
+	}
+	th := NewFakeTaskHandler()
+	wp := NewWorkerPool(th, 1, 1, 1, workerFunc, func(context.Context) {}, func(context.Context) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	wp.Run(ctx)
+	select {
+	case <-cancelled:
+		t.Fatal("收到退出信号时不应取消进行中的任务")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer drainCancel()
+	if err := wp.Drain(drainCtx); err == nil {
+		t.Error("进行中的任务没有完成，应当返回超时错误")
+	}
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("任务的 ctx 错误: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("等待超时后没有取消进行中的任务")
 	}
-	wp := NewWorkerPool(NewFakeTaskHandler(), 5, 10, 50, workerFunc, workerSleepFunc, taskHandlerSleepFunc)
-	wp.Run()
 }
@@ -0,0 +1,161 @@
+package spider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultBulkInterval = time.Second * 2 // 不足一批时，最多等待多久保存一次
+	DefaultBulkBuffer   = 200             // 缓冲区容量，满了之后 worker 会阻塞
+)
+
+// ErrWriterClosed 表示 BulkWriter 已经关闭，不再接受新的专利
+var ErrWriterClosed = errors.New("批量写入已关闭")
+
+// BulkWriter 缓存待保存的专利，攒够 size 个或每隔 interval 在一个事务中用多行语句保存
+// 所有写入都在一个 goroutine 中完成，只占用一个数据库连接；缓冲区满时 Add 阻塞，worker 随之变慢
+type BulkWriter struct {
+	th       TaskHandler
+	size     int
+	interval time.Duration
+	items    chan PendingPatent
+
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{}  // Close 时关闭，唤醒阻塞在缓冲区上的 Add
+	senders sync.WaitGroup // 进行中的 Add，全部返回后才关闭 items
+	done    chan struct{}  // 写入的 goroutine 退出时关闭
+}
+
+// NewBulkWriter 创建并启动 BulkWriter，buffer 不能小于 size
+func NewBulkWriter(th TaskHandler, size int, interval time.Duration, buffer int) *BulkWriter {
+	if size < 1 {
+		size = 1
+	}
+	if interval <= 0 {
+		interval = DefaultBulkInterval
+	}
+	if buffer < size {
+		buffer = size
+	}
+	w := &BulkWriter{
+		th:       th,
+		size:     size,
+		interval: interval,
+		items:    make(chan PendingPatent, buffer),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+// Add 把专利加入缓冲区，缓冲区满时阻塞，ctx 结束或 BulkWriter 关闭时返回错误
+// 加入后任务还没有完成，保存失败的任务之后会重新爬取
+func (w *BulkWriter) Add(ctx context.Context, item PendingPatent) error {
+	// 只在检查是否关闭时持有锁，阻塞等待缓冲区时不持有，Close 不会被阻塞的 Add 卡住
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return ErrWriterClosed
+	}
+	w.senders.Add(1)
+	w.mu.RUnlock()
+	defer w.senders.Done()
+
+	if item.CrawledAt.IsZero() {
+		item.CrawledAt = time.Now()
+	}
+	// 缓冲区没满时直接加入，即使 ctx 已经结束，退出时进行中的任务也能保存
+	select {
+	case w.items <- item:
+		return nil
+	default:
+	}
+	select {
+	case w.items <- item:
+		return nil
+	case <-w.closing:
+		return ErrWriterClosed
+	case <-ctx.Done():
+		return fmt.Errorf("等待批量写入的缓冲区超时: %w", ctx.Err())
+	}
+}
+
+func (w *BulkWriter) loop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]PendingPatent, 0, w.size)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		w.flush(batch)
+		batch = make([]PendingPatent, 0, w.size)
+	}
+	for {
+		select {
+		case item, ok := <-w.items:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= w.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// flush 保存一批专利，整批失败时逐个保存，避免一个专利的问题导致整批任务都要重新爬取
+func (w *BulkWriter) flush(batch []PendingPatent) {
+	start := time.Now()
+	err := w.th.SavePatents(batch)
+	if err == nil {
+		logrus.Infof("批量保存了 %d 个专利，用时 %s", len(batch), time.Since(start))
+		for _, item := range batch {
+			item.saved()
+		}
+		return
+	}
+	logrus.Errorf("批量保存 %d 个专利失败，改为逐个保存: %v", len(batch), err)
+	for _, item := range batch {
+		if err := w.th.SavePatents([]PendingPatent{item}); err != nil {
+			logrus.Errorf("保存专利失败，任务之后会重新爬取: %s, %v", item.Patent.PublicationNo, err)
+			continue
+		}
+		item.saved()
+	}
+}
+
+// Close 不再接受新的专利，并保存缓冲区中剩余的专利，ctx 结束时不再等待
+// 阻塞在缓冲区上的 Add 返回 ErrWriterClosed，它们全部返回后才关闭缓冲区
+func (w *BulkWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.closing)
+		go func() {
+			w.senders.Wait()
+			close(w.items)
+		}()
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("等待批量写入超时，缓冲区中的专利没有保存，任务之后会重新爬取: %w", ctx.Err())
+	}
+}
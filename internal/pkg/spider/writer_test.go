@@ -0,0 +1,146 @@
+package spider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testPatent(i int) *Patent {
+	return &Patent{PublicationNo: fmt.Sprintf("CN%09dA", i)}
+}
+
+func TestBulkWriter(t *testing.T) {
+	ctx := context.Background()
+
+	// 攒够一批时保存，关闭时保存剩余的
+	th := NewFakeTaskHandler()
+	w := NewBulkWriter(th, 3, time.Hour, 10)
+	for i := 1; i <= 7; i++ {
+		if err := w.Add(ctx, PendingPatent{TaskID: uint(i), Patent: testPatent(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(th.SavedBatches, []int{3, 3, 1}) || len(th.SavedPatents) != 7 {
+		t.Errorf("批量保存错误: %v, 共 %d 个", th.SavedBatches, len(th.SavedPatents))
+	}
+	if err := w.Add(ctx, PendingPatent{TaskID: 8, Patent: testPatent(8)}); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("关闭后 Add 返回 %v", err)
+	}
+
+	// 不足一批时定时保存
+	th = NewFakeTaskHandler()
+	w = NewBulkWriter(th, 10, time.Millisecond*20, 10)
+	for i := 1; i <= 2; i++ {
+		if err := w.Add(ctx, PendingPatent{TaskID: uint(i), Patent: testPatent(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		th.mu.Lock()
+		saved := len(th.SavedPatents)
+		th.mu.Unlock()
+		if saved == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("超过保存间隔后仍然没有保存，已保存 %d 个", saved)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	if err := w.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blockingTaskHandler 的 SavePatents 在 release 关闭前一直阻塞，模拟数据库很慢
+type blockingTaskHandler struct {
+	*FakeTaskHandler
+	release chan struct{}
+}
+
+func (b *blockingTaskHandler) SavePatents(batch []PendingPatent) error {
+	<-b.release
+	return b.FakeTaskHandler.SavePatents(batch)
+}
+
+func TestBulkWriterBackpressure(t *testing.T) {
+	th := &blockingTaskHandler{FakeTaskHandler: NewFakeTaskHandler(), release: make(chan struct{})}
+	w := NewBulkWriter(th, 1, time.Hour, 1)
+	ctx := context.Background()
+
+	// 第一个专利正在保存，第二个在缓冲区中，第三个只能等待
+	if err := w.Add(ctx, PendingPatent{TaskID: 1, Patent: testPatent(1)}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(w.items) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.Add(ctx, PendingPatent{TaskID: 2, Patent: testPatent(2)}); err != nil {
+		t.Fatal(err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	if err := w.Add(timeoutCtx, PendingPatent{TaskID: 3, Patent: testPatent(3)}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("缓冲区满时 Add 返回 %v", err)
+	}
+
+	close(th.release)
+	if err := w.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(th.SavedPatents) != 2 {
+		t.Errorf("保存了 %d 个专利, want 2", len(th.SavedPatents))
+	}
+}
+
+// 数据库卡住时，Close 不会被阻塞的 Add 卡住，按自己的 ctx 返回
+func TestBulkWriterCloseWithBlockedAdd(t *testing.T) {
+	th := &blockingTaskHandler{FakeTaskHandler: NewFakeTaskHandler(), release: make(chan struct{})}
+	defer close(th.release)
+	w := NewBulkWriter(th, 1, time.Hour, 1)
+	ctx := context.Background()
+
+	if err := w.Add(ctx, PendingPatent{TaskID: 1, Patent: testPatent(1)}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(w.items) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.Add(ctx, PendingPatent{TaskID: 2, Patent: testPatent(2)}); err != nil {
+		t.Fatal(err)
+	}
+	// 第三个专利一直阻塞在缓冲区上
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- w.Add(ctx, PendingPatent{TaskID: 3, Patent: testPatent(3)})
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	closeCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	if err := w.Close(closeCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("数据库卡住时 Close 返回 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close 用了 %s，没有按 ctx 返回", elapsed)
+	}
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, ErrWriterClosed) {
+			t.Errorf("关闭时阻塞的 Add 返回 %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("关闭后阻塞的 Add 没有返回")
+	}
+}